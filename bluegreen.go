@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codedeploy"
+	cdtypes "github.com/aws/aws-sdk-go-v2/service/codedeploy/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/toolsdotgo/sfm/pkg/sfm"
+)
+
+// how long deploy waits on a CodeDeploy deployment, slow linear traffic shifting takes a while
+const blueGreenTimeout = 3 * time.Hour
+
+// how often the CodeDeploy deployment is polled
+const blueGreenPollInterval = 5 * time.Second
+
+// appSpec is the AppSpec CodeDeploy takes for ECS deployments, the properties are ECS's own
+// so their SDK types marshal to what it expects
+type appSpec struct {
+	Version   json.RawMessage              `json:"version"`
+	Resources []map[string]appSpecResource `json:"Resources"`
+}
+
+type appSpecResource struct {
+	Type       string            `json:"Type"`
+	Properties appSpecProperties `json:"Properties"`
+}
+
+type appSpecProperties struct {
+	TaskDefinition           string                               `json:"TaskDefinition"`
+	LoadBalancerInfo         appSpecLoadBalancer                  `json:"LoadBalancerInfo"`
+	PlatformVersion          string                               `json:"PlatformVersion,omitempty"`
+	NetworkConfiguration     *types.NetworkConfiguration          `json:"NetworkConfiguration,omitempty"`
+	CapacityProviderStrategy []types.CapacityProviderStrategyItem `json:"CapacityProviderStrategy,omitempty"`
+}
+
+type appSpecLoadBalancer struct {
+	ContainerName string `json:"ContainerName"`
+	ContainerPort int32  `json:"ContainerPort"`
+}
+
+// pinServiceTaskDefinition keeps a blue/green service's task definition in the stack where it was,
+// as only CodeDeploy can move the service on to a new one and the stack fails to update when it changes
+func pinServiceTaskDefinition(values map[string]interface{}, s sfm.Stack) {
+	if fmt.Sprint(getValue(values, "aws.ecs.deployment.strategy")) != "blue_green" {
+		return
+	}
+	if td := s.Outputs["ServiceTaskDefinition"]; td != "" {
+		values["_service_task_definition"] = td
+	}
+}
+
+// pinLiveTargetGroup keeps a blue/green stack's listeners and listener rules forwarding to the target group
+// CodeDeploy last moved traffic to, as the stack otherwise sends it back to the blue target group, which has
+// no tasks every other deployment, whenever it updates them
+func pinLiveTargetGroup(values map[string]interface{}, s sfm.Stack) error {
+	if fmt.Sprint(getValue(values, "aws.ecs.deployment.strategy")) != "blue_green" || s.Created.IsZero() {
+		return nil
+	}
+	svc, err := stackService(ecs.NewFromConfig(cfg), s)
+	if err != nil {
+		return err
+	}
+	if lbs := primaryTaskSet(svc).LoadBalancers; len(lbs) > 0 {
+		values["_live_target_group"] = aws.ToString(lbs[0].TargetGroupArn)
+	}
+	return nil
+}
+
+// deployBlueGreen has CodeDeploy move the stack's service on to the stack's task definition,
+// printing what the deployment and the service do until it finishes
+func deployBlueGreen(s sfm.Stack, watch *serviceWatcher) int {
+	client := ecs.NewFromConfig(cfg)
+	svc, err := stackService(client, s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get service: %v\n", err)
+		return 1
+	}
+	target := s.Outputs["TaskDefinition"]
+	current := primaryTaskSet(svc)
+	if aws.ToString(current.TaskDefinition) == target {
+		return 0
+	}
+	if len(current.LoadBalancers) < 1 {
+		fmt.Fprintf(os.Stderr, "service %s has no load balancer for CodeDeploy to move traffic on\n", aws.ToString(svc.ServiceName))
+		return 1
+	}
+	lb := current.LoadBalancers[0]
+	spec := appSpec{
+		Version: json.RawMessage("0.0"),
+		Resources: []map[string]appSpecResource{{
+			"TargetService": {
+				Type: "AWS::ECS::Service",
+				Properties: appSpecProperties{
+					TaskDefinition:           target,
+					LoadBalancerInfo:         appSpecLoadBalancer{ContainerName: aws.ToString(lb.ContainerName), ContainerPort: aws.ToInt32(lb.ContainerPort)},
+					PlatformVersion:          aws.ToString(current.PlatformVersion),
+					NetworkConfiguration:     current.NetworkConfiguration,
+					CapacityProviderStrategy: current.CapacityProviderStrategy,
+				},
+			},
+		}},
+	}
+	content, err := json.Marshal(spec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant marshal appspec: %v\n", err)
+		return 1
+	}
+
+	if bk {
+		fmt.Println("+++ Deploying with CodeDeploy")
+	}
+	cd := codedeploy.NewFromConfig(cfg)
+	out, err := cd.CreateDeployment(context.TODO(), &codedeploy.CreateDeploymentInput{
+		ApplicationName:     aws.String(s.Outputs["CodeDeployApplication"]),
+		DeploymentGroupName: aws.String(s.Outputs["CodeDeployDeploymentGroup"]),
+		Description:         aws.String(fmt.Sprintf("yeet deploy of %s", lastSegment(target, "/"))),
+		Revision: &cdtypes.RevisionLocation{
+			RevisionType:   cdtypes.RevisionLocationTypeAppSpecContent,
+			AppSpecContent: &cdtypes.AppSpecContent{Content: aws.String(string(content))},
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant create CodeDeploy deployment: %v\n", err)
+		return 1
+	}
+	id := aws.ToString(out.DeploymentId)
+	fmt.Printf("Started CodeDeploy deployment %s from %s to %s\n", id, lastSegment(aws.ToString(current.TaskDefinition), "/"), lastSegment(target, "/"))
+	return waitBlueGreen(cd, s, id, watch)
+}
+
+// waitBlueGreen prints the deployment's status, lifecycle events and traffic weights as they change
+// along with what the service is doing, until the deployment succeeds, fails or is stopped
+func waitBlueGreen(cd *codedeploy.Client, s sfm.Stack, id string, watch *serviceWatcher) int {
+	status := ""
+	events := map[string]cdtypes.LifecycleEventStatus{}
+	weights := ""
+	for start := time.Now(); time.Since(start) < blueGreenTimeout; {
+		watch.poll(s)
+		d, err := cd.GetDeployment(context.TODO(), &codedeploy.GetDeploymentInput{DeploymentId: aws.String(id)})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cant get CodeDeploy deployment: %v\n", err)
+			return 1
+		}
+		info := d.DeploymentInfo
+		if string(info.Status) != status {
+			status = string(info.Status)
+			msg := fmt.Sprintf("deployment %s", id)
+			if info.ErrorInformation != nil {
+				msg = fmt.Sprintf("%s: %s", msg, aws.ToString(info.ErrorInformation.Message))
+			}
+			printWatch(time.Now(), "CodeDeploy", status, msg)
+		}
+
+		t, err := ecsTarget(cd, id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cant get CodeDeploy deployment target: %v\n", err)
+			return 1
+		}
+		if t != nil {
+			for _, e := range t.LifecycleEvents {
+				name := aws.ToString(e.LifecycleEventName)
+				if e.Status == cdtypes.LifecycleEventStatusPending || events[name] == e.Status {
+					continue
+				}
+				events[name] = e.Status
+				msg := name
+				if e.Diagnostics != nil && e.Diagnostics.Message != nil {
+					msg = fmt.Sprintf("%s: %s", msg, *e.Diagnostics.Message)
+				}
+				printWatch(time.Now(), "CodeDeploy", string(e.Status), msg)
+			}
+			ww := []string{}
+			for _, ts := range t.TaskSetsInfo {
+				ww = append(ww, fmt.Sprintf("%s %.0f%% (%d running)", ts.TaskSetLabel, ts.TrafficWeight, ts.RunningCount))
+			}
+			if w := strings.Join(ww, ", "); w != weights {
+				weights = w
+				printWatch(time.Now(), "CodeDeploy", "TRAFFIC", w)
+			}
+		}
+
+		switch info.Status {
+		case cdtypes.DeploymentStatusSucceeded:
+			return 0
+		case cdtypes.DeploymentStatusFailed, cdtypes.DeploymentStatusStopped:
+			if info.RollbackInfo != nil {
+				fmt.Fprintf(os.Stderr, "rolled back: %s\n", aws.ToString(info.RollbackInfo.RollbackMessage))
+			}
+			return 1
+		}
+		time.Sleep(blueGreenPollInterval)
+	}
+	fmt.Fprintf(os.Stderr, "CodeDeploy deployment wait timed out, took longer than %s\n", blueGreenTimeout)
+	return 1
+}
+
+// ecsTarget returns the ECS target of a deployment, which is nil until CodeDeploy has one
+func ecsTarget(cd *codedeploy.Client, id string) (*cdtypes.ECSTarget, error) {
+	list, err := cd.ListDeploymentTargets(context.TODO(), &codedeploy.ListDeploymentTargetsInput{DeploymentId: aws.String(id)})
+	if err != nil {
+		return nil, err
+	}
+	if len(list.TargetIds) < 1 {
+		return nil, nil
+	}
+	t, err := cd.GetDeploymentTarget(context.TODO(), &codedeploy.GetDeploymentTargetInput{
+		DeploymentId: aws.String(id),
+		TargetId:     aws.String(list.TargetIds[0]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t.DeploymentTarget.EcsTarget, nil
+}
+
+// primaryTaskSet returns the task set taking the service's traffic, services deployed by CodeDeploy
+// keep their task definition and network configuration in task sets rather than on the service
+func primaryTaskSet(svc types.Service) types.TaskSet {
+	for _, ts := range svc.TaskSets {
+		if aws.ToString(ts.Status) == "PRIMARY" {
+			return ts
+		}
+	}
+	return types.TaskSet{
+		TaskDefinition:           svc.TaskDefinition,
+		LoadBalancers:            svc.LoadBalancers,
+		PlatformVersion:          svc.PlatformVersion,
+		NetworkConfiguration:     svc.NetworkConfiguration,
+		CapacityProviderStrategy: svc.CapacityProviderStrategy,
+	}
+}
+
+// checkBlueGreen reports config CodeDeploy can't deploy blue/green, it moves traffic between
+// two target groups of a single ALB on a single listener
+func checkBlueGreen(values map[string]interface{}) []problem {
+	pp := []problem{}
+	albs := assertMSI(getValue(values, "aws.application_load_balancers"))
+	if fmt.Sprint(getValue(values, "aws.ecs.deployment.strategy")) != "blue_green" {
+		for _, name := range sortedKeys(albs) {
+			alb := assertMSI(albs[name])
+			if _, ok := alb["test_listener_arn"]; ok {
+				pp = append(pp, problem{[]string{"aws", "application_load_balancers", name, "test_listener_arn"}, "only used by blue_green deployments"})
+			}
+			if getValue(alb, "load_balancer.test_port") != nil {
+				pp = append(pp, problem{[]string{"aws", "application_load_balancers", name, "load_balancer", "test_port"}, "only used by blue_green deployments"})
+			}
+		}
+		return pp
+	}
+
+	path := []string{"aws", "ecs", "deployment", "strategy"}
+	if len(albs) != 1 {
+		pp = append(pp, problem{path, fmt.Sprintf("blue_green needs exactly one application load balancer, there are %d", len(albs))})
+	}
+	if nlbs := assertMSI(getValue(values, "aws.network_load_balancers")); len(nlbs) > 0 {
+		pp = append(pp, problem{path, "blue_green can't be used with network_load_balancers"})
+	}
+	for _, name := range sortedKeys(albs) {
+		alb := assertMSI(albs[name])
+		if t, ok := alb["target_group"]; ok {
+			pp = append(pp, problem{[]string{"aws", "application_load_balancers", name, "target_group"}, "blue_green needs the target groups yeet creates, not target_group " + fmt.Sprint(t)})
+		}
+		if _, ok := alb["test_listener_arn"]; ok && getValue(alb, "load_balancer.test_port") != nil {
+			pp = append(pp, problem{[]string{"aws", "application_load_balancers", name}, "set either test_listener_arn or load_balancer.test_port, not both"})
+		}
+		// rules without a listener_arn are on the listener yeet creates
+		listeners := map[string]struct{}{}
+		if alb["load_balancer"] != nil {
+			listeners[""] = struct{}{}
+		}
+		rules := assertMSI(alb["listener_rules"])
+		for _, rule := range sortedKeys(rules) {
+			if arn, ok := assertMSI(rules[rule])["listener_arn"]; ok {
+				listeners[fmt.Sprint(arn)] = struct{}{}
+			}
+		}
+		if len(listeners) > 1 {
+			pp = append(pp, problem{[]string{"aws", "application_load_balancers", name, "listener_rules"}, "blue_green can only move traffic on one listener, the rules use more"})
+		}
+	}
+	tts := assertMSI(getValue(values, "scaling.target_tracking"))
+	for _, name := range sortedKeys(tts) {
+		if fmt.Sprint(assertMSI(tts[name])["metric"]) == "requests" {
+			pp = append(pp, problem{[]string{"scaling", "target_tracking", name, "metric"}, "requests are counted on one target group, which blue_green deployments move traffic off"})
+		}
+	}
+	return pp
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elb "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbtypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/toolsdotgo/sfm/pkg/sfm"
+)
+
+// the canary stack is named after the stack it's a canary of
+const canarySuffix = "-canary"
+
+// requests with this header set to true always go to the canary, so it can be tried before it takes any weight
+const canaryHeader = "X-Yeet-Canary"
+
+// canaryYeet deploys the config as a canary stack next to the stack it's a canary of and sends weight percent
+// of the requests its ALB listener rules forward to the canary. Promoting deploys the config to the stack and
+// removes the canary, aborting just removes the canary.
+func (c command) canaryYeet(action string, args []string, region string, weight int, tagsfile string) int {
+	values, org, err := readValuesWithOrigins(defaults, args, region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read values: %v", err)
+		return 1
+	}
+	stackname, ok := values["name"].(string) // sorry
+	if !ok {
+		fmt.Fprintf(os.Stderr, "no stack name found")
+		return 1
+	}
+
+	h := sfm.Handle{CFNcli: c.cfnc}
+	primary := h.NewStack(stackname)
+	if primary.Created.IsZero() {
+		fmt.Fprintf(os.Stderr, "stack %s doesn't exist, deploy it before a canary\n", stackname)
+		return 1
+	}
+	client := elb.NewFromConfig(cfg)
+	if action == "abort" {
+		return c.removeCanary(h, client, stackname, values)
+	}
+
+	pp, err := validateValues(values)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant validate config: %v\n", err)
+		return 1
+	}
+	if len(pp) > 0 {
+		printProblems(pp, org)
+		fmt.Fprintln(os.Stderr, "not deploying invalid config")
+		return 1
+	}
+
+	if action == "promote" {
+		canary, err := h.Get(stackname + canarySuffix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cant get canary stack: %v\n", err)
+			return 1
+		}
+		if bk {
+			fmt.Println("+++ Shifting all requests to the canary")
+		}
+		if err := setCanaryWeights(client, primary, canary, values, 100); err != nil {
+			fmt.Fprintf(os.Stderr, "cant shift requests to the canary: %v\n", err)
+			return 1
+		}
+		if rc := c.deployValues(values, args, deployFlags{tagsfile: tagsfile, promote: true}); rc != 0 {
+			fmt.Fprintf(os.Stderr, "the canary is still taking all requests, run yeet canary promote again or abort\n")
+			return rc
+		}
+		return c.removeCanary(h, client, stackname, values)
+	}
+
+	cv, err := readValues(defaults, args, region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read values: %v", err)
+		return 1
+	}
+	if err := canaryValues(cv, primary); err != nil {
+		fmt.Fprintf(os.Stderr, "cant make canary config: %v\n", err)
+		return 1
+	}
+	if rc := c.deployValues(cv, args, deployFlags{tagsfile: tagsfile}); rc != 0 {
+		return rc
+	}
+
+	canary, err := h.Get(stackname + canarySuffix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get canary stack: %v\n", err)
+		return 1
+	}
+	if bk {
+		fmt.Println("+++ Shifting requests to the canary")
+	}
+	if err := setCanaryWeights(client, primary, canary, values, weight); err != nil {
+		fmt.Fprintf(os.Stderr, "cant shift requests to the canary: %v\n", err)
+		return 1
+	}
+	fmt.Println()
+	fmt.Printf("Canary %s is taking %d%% of requests, and all requests with %s: true\n", canary.Name, weight, canaryHeader)
+	files := strings.Join(args, " ")
+	promote := files
+	if tagsfile != "" {
+		promote = "-tf " + tagsfile + " " + files
+	}
+	fmt.Printf("Run yeet -r %s canary promote %s to deploy it to the stack, or yeet -r %s canary abort %s to remove it\n", region, promote, region, files)
+	return 0
+}
+
+// removeCanary sends every request back to the stack and deletes the canary stack
+func (c command) removeCanary(h sfm.Handle, client *elb.Client, stackname string, values map[string]interface{}) int {
+	primary, err := h.Get(stackname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get stack: %v\n", err)
+		return 1
+	}
+	canary, err := h.Get(stackname + canarySuffix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get canary stack: %v\n", err)
+		return 1
+	}
+	if bk {
+		fmt.Println("+++ Shifting all requests back from the canary")
+	}
+	// the canary's target groups can't be deleted while a rule forwards to them
+	if err := setCanaryWeights(client, primary, canary, values, 0); err != nil {
+		fmt.Fprintf(os.Stderr, "cant shift requests back from the canary: %v\n", err)
+		return 1
+	}
+
+	stackid, err := c.stackID(canary.Name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get stack id: %v\n", err)
+		return 1
+	}
+	if bk {
+		fmt.Println("+++ Destroying Yeet Canary Stack")
+	}
+	token, err := h.Delete(canary.Name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to delete stack: %v", err)
+		return 1
+	}
+	return waitDelete(h, stackid, token)
+}
+
+// canaryValues turns read values into the canary's. It gets its own service and target groups but
+// nothing that's only wanted once, and each ALB gets a listener rule on the stack's listener which
+// only matches the canary header, registering the canary's target group with the load balancer.
+func canaryValues(values map[string]interface{}, primary sfm.Stack) error {
+	if fmt.Sprint(getValue(values, "aws.ecs.deployment.strategy")) == "blue_green" {
+		return fmt.Errorf("blue/green deployments already shift traffic with CodeDeploy")
+	}
+	albs := assertMSI(getValue(values, "aws.application_load_balancers"))
+	if len(albs) == 0 {
+		return fmt.Errorf("canaries need an application load balancer to share requests on")
+	}
+	rr, err := primary.Resources()
+	if err != nil {
+		return fmt.Errorf("cant get stack resources: %v", err)
+	}
+
+	for _, k := range sortedKeys(albs) {
+		alb := assertMSI(albs[k])
+		if alb["target_group"] != nil {
+			return fmt.Errorf("application load balancer %s uses target_group %v, canaries need target groups yeet creates", k, alb["target_group"])
+		}
+		listener := ""
+		if alb["load_balancer"] != nil {
+			listener = rr[logicalID(k, "Listener")]["pid"]
+		} else if rules := assertMSI(alb["listener_rules"]); len(rules) > 0 {
+			listener = fmt.Sprint(assertMSI(rules[sortedKeys(rules)[0]])["listener_arn"])
+		}
+		if listener == "" {
+			return fmt.Errorf("application load balancer %s has no listener to add the canary to", k)
+		}
+		alb["listener_rules"] = map[string]interface{}{
+			"canary": map[string]interface{}{
+				"listener_arn": listener,
+				"http_headers": map[string]interface{}{canaryHeader: "true"},
+			},
+		}
+		delete(alb, "load_balancer")
+		albs[k] = alb
+	}
+	setValue(values, "aws.application_load_balancers", albs)
+
+	values["name"] = fmt.Sprint(values["name"]) + canarySuffix
+	if n := getValue(values, "aws.ecs.name"); n != nil {
+		setValue(values, "aws.ecs.name", fmt.Sprint(n)+canarySuffix)
+	}
+	setValue(values, "aws.ecs.deployment.strategy", "rolling")
+	for _, p := range []string{"schedules", "aws.network_load_balancers", "aws.service_discovery", "aws.service_connect.services", "scaling.queue", "scaling.step_scaling", "scaling.target_tracking", "aws.ecs.task.export_security_group"} {
+		deleteValue(values, p)
+	}
+
+	// the stack's task security group already lets its load balancers and everything else in
+	if r, ok := rr["TaskSG"]; ok {
+		sgs := asList(getValue(values, "aws.ecs.task.security_groups"))
+		setValue(values, "aws.ecs.task.security_groups", append(sgs, r["pid"]))
+		deleteValue(values, "aws.ecs.task.ingress")
+		deleteValue(values, "aws.ecs.task.egress")
+	}
+	return nil
+}
+
+// setCanaryWeights makes the forwarding rules of each of the stack's ALBs, and the listener yeet creates
+// for them, send weight percent of requests to the canary's target group and the rest to the stack's.
+// A weight of 0 forwards only to the stack's target group, the same as it's deployed.
+func setCanaryWeights(client *elb.Client, primary sfm.Stack, canary sfm.Stack, values map[string]interface{}, weight int) error {
+	prr, err := primary.Resources()
+	if err != nil {
+		return fmt.Errorf("cant get stack resources: %v", err)
+	}
+	crr, err := canary.Resources()
+	if err != nil {
+		return fmt.Errorf("cant get canary stack resources: %v", err)
+	}
+
+	albs := assertMSI(getValue(values, "aws.application_load_balancers"))
+	for _, k := range sortedKeys(albs) {
+		alb := assertMSI(albs[k])
+		tg := prr[logicalID(k, "TargetGroup")]["pid"]
+		ctg := crr[logicalID(k, "TargetGroup")]["pid"]
+		if tg == "" || ctg == "" {
+			return fmt.Errorf("application load balancer %s has no target group in both stacks", k)
+		}
+
+		rules := assertMSI(alb["listener_rules"])
+		for _, lk := range sortedKeys(rules) {
+			r := assertMSI(rules[lk])
+			if r["fixed_response"] != nil || r["redirect"] != nil {
+				continue
+			}
+			arn := prr[logicalID(k, lk, "ListenerRule")]["pid"]
+			if arn == "" {
+				return fmt.Errorf("stack has no listener rule %s for application load balancer %s", lk, k)
+			}
+			out, err := client.DescribeRules(context.TODO(), &elb.DescribeRulesInput{RuleArns: []string{arn}})
+			if err != nil {
+				return fmt.Errorf("cant describe listener rule %s: %v", lk, err)
+			}
+			if len(out.Rules) != 1 {
+				return fmt.Errorf("only a single listener rule should be returned, %v found", len(out.Rules))
+			}
+			_, err = client.ModifyRule(context.TODO(), &elb.ModifyRuleInput{
+				RuleArn: aws.String(arn),
+				Actions: canaryActions(out.Rules[0].Actions, tg, ctg, weight),
+			})
+			if err != nil {
+				return fmt.Errorf("cant modify listener rule %s: %v", lk, err)
+			}
+		}
+
+		if alb["load_balancer"] == nil {
+			continue
+		}
+		arn := prr[logicalID(k, "Listener")]["pid"]
+		out, err := client.DescribeListeners(context.TODO(), &elb.DescribeListenersInput{ListenerArns: []string{arn}})
+		if err != nil {
+			return fmt.Errorf("cant describe listener for application load balancer %s: %v", k, err)
+		}
+		if len(out.Listeners) != 1 {
+			return fmt.Errorf("only a single listener should be returned, %v found", len(out.Listeners))
+		}
+		_, err = client.ModifyListener(context.TODO(), &elb.ModifyListenerInput{
+			ListenerArn:    aws.String(arn),
+			DefaultActions: canaryActions(out.Listeners[0].DefaultActions, tg, ctg, weight),
+		})
+		if err != nil {
+			return fmt.Errorf("cant modify listener for application load balancer %s: %v", k, err)
+		}
+	}
+	return nil
+}
+
+// canaryActions returns the actions with the forward shared between the target groups by weight
+func canaryActions(actions []elbtypes.Action, tg string, ctg string, weight int) []elbtypes.Action {
+	aa := []elbtypes.Action{}
+	for _, a := range actions {
+		switch a.Type {
+		case elbtypes.ActionTypeEnumForward:
+			a.TargetGroupArn, a.ForwardConfig = nil, nil
+			if weight == 0 {
+				a.TargetGroupArn = aws.String(tg)
+				break
+			}
+			a.ForwardConfig = &elbtypes.ForwardActionConfig{
+				TargetGroups: []elbtypes.TargetGroupTuple{
+					{TargetGroupArn: aws.String(tg), Weight: aws.Int32(int32(100 - weight))},
+					{TargetGroupArn: aws.String(ctg), Weight: aws.Int32(int32(weight))},
+				},
+			}
+		case elbtypes.ActionTypeEnumAuthenticateOidc:
+			// describing a rule doesn't return the client secret
+			a.AuthenticateOidcConfig.ClientSecret = nil
+			a.AuthenticateOidcConfig.UseExistingClientSecret = aws.Bool(true)
+		}
+		aa = append(aa, a)
+	}
+	return aa
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cfntypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/toolsdotgo/sfm/pkg/sfm"
+)
+
+// the same capabilities sfm uses when it makes a stack
+var changeSetCaps = []cfntypes.Capability{cfntypes.CapabilityCapabilityNamedIam, cfntypes.CapabilityCapabilityAutoExpand}
+
+func (c command) diffYeet(args []string, region string, tagsfile string) int {
+	values, err := readValues(defaults, args, region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read values: %v", err)
+		return 1
+	}
+	stackname, ok := values["name"].(string) // sorry
+	if !ok {
+		fmt.Fprintf(os.Stderr, "no stack name found")
+		return 1
+	}
+
+	h := sfm.Handle{CFNcli: c.cfnc}
+	stack := h.NewStack(stackname)
+	template, err := stackTemplate(values, stack)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed generate template: %v", err)
+		return 1
+	}
+
+	if err := stack.NewTemplate([]byte(template)); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load template into stack: %v", err)
+		return 1
+	}
+
+	stack.Tags, err = loadTags(tagsfile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant load tags: %v", err)
+		return 1
+	}
+
+	if bk {
+		fmt.Println("+++ Yeet Stack changes")
+	}
+
+	if stack.Created.IsZero() || stack.Status == string(cfntypes.StackStatusReviewInProgress) {
+		// a change set against a stack that doesn't exist leaves an empty stack behind, so just list the template
+		fmt.Printf("Stack %s doesn't exist yet, every resource will be added\n\n", stackname)
+		printTemplateChanges(stack.Template)
+		return 0
+	}
+
+	id, err := c.createChangeSet(stack, changeSetName())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant create change set: %v\n", err)
+		return 1
+	}
+	defer func() {
+		if err := c.deleteChangeSet(stackname, id); err != nil {
+			fmt.Fprintf(os.Stderr, "cant delete change set %s: %v\n", id, err)
+		}
+	}()
+
+	changes, err := c.waitChangeSet(stackname, id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "change set failed: %v\n", err)
+		return 1
+	}
+	printChanges(changes)
+	return 0
+}
+
+// changeSetYeet creates a change set for the stack and prints it, then either applies it once confirmed
+// or leaves it for a later `yeet deploy -execute`
+func (c command) changeSetYeet(h sfm.Handle, stack sfm.Stack, args []string, df deployFlags, cluster string) int {
+	// a CI job asking to confirm would otherwise pass without deploying anything
+	if !df.changesetOnly && !isTerminal(os.Stdin) {
+		fmt.Fprintln(os.Stderr, "no terminal to confirm on, use -changeset-only to create a change set without applying it")
+		return 1
+	}
+	if bk {
+		fmt.Println("+++ Yeet Stack changes")
+	}
+
+	name := changeSetName()
+	id, err := c.createChangeSet(stack, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant create change set: %v\n", err)
+		return 1
+	}
+	changes, err := c.waitChangeSet(stack.Name, id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "change set failed: %v\n", err)
+		c.discardChangeSet(h, stack, id)
+		return 1
+	}
+	printChanges(changes)
+	fmt.Println()
+	if len(changes) < 1 {
+		c.discardChangeSet(h, stack, id)
+		return 0
+	}
+
+	if df.changesetOnly {
+		fmt.Printf("Change set %s created for %s, apply it with:\n", name, stack.Name)
+		fmt.Printf("  yeet deploy -execute %s %s\n", name, strings.Join(args, " "))
+		return 0
+	}
+
+	if !confirm(fmt.Sprintf("Deploy these changes to %s? [y/N] ", stack.Name)) {
+		fmt.Println("Not deploying, discarding change set")
+		c.discardChangeSet(h, stack, id)
+		return 1
+	}
+	return c.executeYeet(h, stack.Name, id, cluster)
+}
+
+// executeYeet prints and applies a change set, then waits for the deploy the same as a regular deploy
+func (c command) executeYeet(h sfm.Handle, stackname string, changeset string, cluster string) int {
+	changes, err := c.waitChangeSet(stackname, changeset)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant use change set %s: %v\n", changeset, err)
+		return 1
+	}
+	if len(changes) < 1 {
+		fmt.Fprintf(os.Stderr, "change set %s has no changes to apply\n", changeset)
+		return 1
+	}
+	printChanges(changes)
+	fmt.Println()
+
+	if bk {
+		fmt.Println("+++ Deploying Yeet Stack")
+	}
+
+	token := requestToken()
+	_, err = c.cfnc.ExecuteChangeSet(context.TODO(), &cloudformation.ExecuteChangeSetInput{
+		StackName:          aws.String(stackname),
+		ChangeSetName:      aws.String(changeset),
+		ClientRequestToken: aws.String(token),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to execute change set: %v", err)
+		return 1
+	}
+
+	return waitDeploy(h, stackname, token, cluster)
+}
+
+// discardChangeSet deletes a change set which won't be applied, along with the stack when the change set created it
+func (c command) discardChangeSet(h sfm.Handle, stack sfm.Stack, id string) {
+	if err := c.deleteChangeSet(stack.Name, id); err != nil {
+		fmt.Fprintf(os.Stderr, "cant delete change set %s: %v\n", id, err)
+	}
+	if !stack.Created.IsZero() {
+		return
+	}
+	// the stack was only created to hold the change set and is still in REVIEW_IN_PROGRESS
+	if _, err := h.Delete(stack.Name); err != nil {
+		fmt.Fprintf(os.Stderr, "cant delete empty stack %s: %v\n", stack.Name, err)
+	}
+}
+
+// requestToken returns a random ClientRequestToken so the events for an operation can be told apart
+func requestToken() string {
+	buff := make([]byte, 16)
+	if _, err := rand.Read(buff); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("yeet-%s", hex.EncodeToString(buff))
+}
+
+// changeSetName returns a name unique enough for a change set created by yeet
+func changeSetName() string {
+	return fmt.Sprintf("yeet-%s", time.Now().UTC().Format("20060102-150405"))
+}
+
+// createChangeSet creates a change set for the stack's template and tags and returns its id
+func (c command) createChangeSet(s sfm.Stack, name string) (string, error) {
+	csType := cfntypes.ChangeSetTypeUpdate
+	if s.Created.IsZero() || s.Status == string(cfntypes.StackStatusReviewInProgress) {
+		csType = cfntypes.ChangeSetTypeCreate
+	}
+	tags := []cfntypes.Tag{}
+	for k, v := range s.Tags {
+		tags = append(tags, cfntypes.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	out, err := c.cfnc.CreateChangeSet(context.TODO(), &cloudformation.CreateChangeSetInput{
+		StackName:     aws.String(s.Name),
+		ChangeSetName: aws.String(name),
+		ChangeSetType: csType,
+		Capabilities:  changeSetCaps,
+		Tags:          tags,
+		TemplateBody:  aws.String(s.TemplateBody),
+		Description:   aws.String(fmt.Sprintf("yeet %s", version)),
+	})
+	if err != nil {
+		return "", err
+	}
+	return *out.Id, nil
+}
+
+// waitChangeSet waits for the change set to finish creating and returns every change in it.
+// A change set that failed because there was nothing to change returns no changes and no error.
+func (c command) waitChangeSet(stackname string, id string) ([]cfntypes.Change, error) {
+	timeout := 10 * time.Minute
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(2 * time.Second) {
+		out, err := c.cfnc.DescribeChangeSet(context.TODO(), &cloudformation.DescribeChangeSetInput{
+			StackName:     aws.String(stackname),
+			ChangeSetName: aws.String(id),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cant describe change set: %v", err)
+		}
+		switch out.Status {
+		case cfntypes.ChangeSetStatusCreatePending, cfntypes.ChangeSetStatusCreateInProgress:
+			continue
+		case cfntypes.ChangeSetStatusCreateComplete:
+			changes := out.Changes
+			for token := out.NextToken; token != nil; token = out.NextToken {
+				out, err = c.cfnc.DescribeChangeSet(context.TODO(), &cloudformation.DescribeChangeSetInput{
+					StackName:     aws.String(stackname),
+					ChangeSetName: aws.String(id),
+					NextToken:     token,
+				})
+				if err != nil {
+					return nil, fmt.Errorf("cant describe change set: %v", err)
+				}
+				changes = append(changes, out.Changes...)
+			}
+			return changes, nil
+		}
+		reason := aws.ToString(out.StatusReason)
+		if strings.Contains(reason, "didn't contain changes") || strings.Contains(reason, "No updates are to be performed") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("change set in %s state: %s", out.Status, reason)
+	}
+	return nil, fmt.Errorf("change set wait timed out, took longer than %s", timeout)
+}
+
+func (c command) deleteChangeSet(stackname string, id string) error {
+	_, err := c.cfnc.DeleteChangeSet(context.TODO(), &cloudformation.DeleteChangeSetInput{
+		StackName:     aws.String(stackname),
+		ChangeSetName: aws.String(id),
+	})
+	return err
+}
+
+// printChanges prints a row per resource in the change set followed by the properties that changed
+func printChanges(changes []cfntypes.Change) {
+	if len(changes) < 1 {
+		fmt.Println("No changes")
+		return
+	}
+	add, modify, remove, replace := 0, 0, 0, 0
+	fmt.Println("Changes:")
+	fmt.Println(" Action | Logical ID                     | Type                                | Replacement")
+	fmt.Println("--------+--------------------------------+-------------------------------------+-------------")
+	for _, ch := range changes {
+		rc := ch.ResourceChange
+		if rc == nil {
+			continue
+		}
+		switch rc.Action {
+		case cfntypes.ChangeActionAdd:
+			add++
+		case cfntypes.ChangeActionModify:
+			modify++
+		case cfntypes.ChangeActionRemove:
+			remove++
+		}
+		if rc.Replacement == cfntypes.ReplacementTrue {
+			replace++
+		}
+		fmt.Printf(" %-6s | %-30s | %-35s | %s\n", rc.Action, aws.ToString(rc.LogicalResourceId), aws.ToString(rc.ResourceType), rc.Replacement)
+
+		// a property can show up in several details when it changes for more than one reason
+		seen := map[string]struct{}{}
+		for _, d := range rc.Details {
+			if d.Target == nil {
+				continue
+			}
+			p := string(d.Target.Attribute)
+			if d.Target.Name != nil {
+				p = fmt.Sprintf("%s.%s", p, *d.Target.Name)
+			}
+			if _, ok := seen[p]; ok {
+				continue
+			}
+			seen[p] = struct{}{}
+			if d.Target.RequiresRecreation != "" && d.Target.RequiresRecreation != cfntypes.RequiresRecreationNever {
+				p = fmt.Sprintf("%s (recreation: %s)", p, d.Target.RequiresRecreation)
+			}
+			fmt.Printf("        |   ~ %s\n", p)
+		}
+	}
+	fmt.Println()
+	fmt.Printf("%d to add, %d to modify (%d replaced), %d to remove\n", add, modify, replace, remove)
+}
+
+// printTemplateChanges prints every resource in the template as being added
+func printTemplateChanges(t sfm.Template) {
+	ids := make([]string, 0, len(t.Resources))
+	for id := range t.Resources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	fmt.Println("Changes:")
+	fmt.Println(" Action | Logical ID                     | Type")
+	fmt.Println("--------+--------------------------------+-------------------------------------")
+	for _, id := range ids {
+		r := assertMSI(t.Resources[id])
+		fmt.Printf(" %-6s | %-30s | %v\n", cfntypes.ChangeActionAdd, id, r["Type"])
+	}
+	fmt.Println()
+	fmt.Printf("%d to add, 0 to modify (0 replaced), 0 to remove\n", len(ids))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cfntypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/toolsdotgo/sfm/pkg/sfm"
+)
+
+func (c command) destroyYeet(args []string, region string, force bool) int {
+	values, err := readValues(defaults, args, region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read values: %v", err)
+		return 1
+	}
+	stackname, ok := values["name"].(string) // sorry
+	if !ok {
+		fmt.Fprintf(os.Stderr, "no stack name found")
+		return 1
+	}
+
+	h := sfm.Handle{CFNcli: c.cfnc}
+	stack, err := h.Get(stackname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get stack: %v\n", err)
+		return 1
+	}
+	if stack.TermProc {
+		fmt.Fprintf(os.Stderr, "stack %s has termination protection enabled, not destroying\n", stackname)
+		return 1
+	}
+
+	// CloudFormation won't delete the task security group while other stacks import it, which it only
+	// says once it has deleted everything else. Listing the imports fails when there are none.
+	if imports, err := c.cfnc.ListImports(context.TODO(), &cloudformation.ListImportsInput{
+		ExportName: aws.String(stackname + taskSGExportSuffix),
+	}); err == nil && len(imports.Imports) > 0 {
+		fmt.Fprintf(os.Stderr, "stacks %s allow the task security group with stack:%s, remove it from them before destroying\n", strings.Join(imports.Imports, ", "), stackname)
+		return 1
+	}
+
+	if bk {
+		fmt.Println("+++ Describe running ECS Tasks before destroy")
+	}
+	_, err = describeService(stack)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant describe service pre-destroy: %v\n", err)
+	}
+	fmt.Println()
+
+	// the log group has DeletionPolicy: Retain so it outlives the stack
+	retained := ""
+	rr, err := stack.Resources()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get stack resources: %v\n", err)
+	}
+	if r, ok := rr["ServiceLogGroup"]; ok {
+		retained = r["pid"]
+	}
+
+	if !force {
+		if !isTerminal(os.Stdin) {
+			fmt.Fprintf(os.Stderr, "no terminal to confirm on, use -force to destroy %s without confirming\n", stackname)
+			return 1
+		}
+		if ask(fmt.Sprintf("Type the stack name (%s) to destroy it: ", stackname)) != stackname {
+			fmt.Println("Stack name didn't match, not destroying")
+			return 1
+		}
+	}
+
+	stackid, err := c.stackID(stackname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get stack id: %v\n", err)
+		return 1
+	}
+
+	if bk {
+		fmt.Println("+++ Destroying Yeet Stack")
+	}
+
+	token, err := h.Delete(stackname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to delete stack: %v", err)
+		return 1
+	}
+	if rc := waitDelete(h, stackid, token); rc != 0 {
+		return rc
+	}
+	if retained != "" {
+		fmt.Println()
+		fmt.Printf("Log group %s was retained, delete it manually if it's no longer needed\n", retained)
+	}
+	return 0
+}
+
+// waitDelete streams stack events for the request token until the stack is deleted,
+// the stack has to be given by id as a deleted stack can't be described by its name
+func waitDelete(h sfm.Handle, stackid string, token string) int {
+	timeout := 60 * time.Minute
+	id := ""
+	for start := time.Now(); time.Since(start) < timeout; {
+		s, err := h.Get(stackid)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cant get stack: %v", err)
+			return 1
+		}
+		ee, err := s.Events(id, token)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cant get events: %v", err)
+			return 1
+		}
+		for _, e := range ee {
+			fmt.Print(e.Pretty())
+			id = e.ID
+		}
+		// match the delete statuses rather than Short, which is also ok for the stack's state before the delete
+		if s.Status == string(cfntypes.StackStatusDeleteComplete) {
+			return 0
+		}
+		if s.Status == string(cfntypes.StackStatusDeleteFailed) {
+			fmt.Fprintf(os.Stderr, "stack in err state: %v\n", s.Status)
+			return 1
+		}
+		time.Sleep(2 * time.Second)
+	}
+	fmt.Fprintf(os.Stderr, "stack operation wait timed out, took longer than %s\n", timeout)
+	return 1
+}
+
+// stackID returns the id of a stack, which a deleted stack can only be described by
+func (c command) stackID(stackname string) (string, error) {
+	out, err := c.cfnc.DescribeStacks(context.TODO(), &cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackname),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(out.Stacks) != 1 {
+		return "", fmt.Errorf("only a single stack should be returned, %v found", len(out.Stacks))
+	}
+	return aws.ToString(out.Stacks[0].StackId), nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/toolsdotgo/sfm/pkg/sfm"
+)
+
+// the command exec runs when it isn't given one
+const execDefaultCommand = "/bin/sh"
+
+// execYeet starts an interactive ECS Exec session in a container of one of the service's running tasks,
+// handing the terminal over to the session manager plugin the same way the aws cli does
+func (c command) execYeet(args []string, region string, task string, container string) int {
+	files, cmd := splitCommand(args)
+	values, err := readValues(defaults, files, region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read values: %v", err)
+		return 1
+	}
+	stackname, ok := values["name"].(string) // sorry
+	if !ok {
+		fmt.Fprintf(os.Stderr, "no stack name found")
+		return 1
+	}
+	container, err = runContainer(values, container)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant pick container: %v\n", err)
+		return 1
+	}
+	plugin, err := exec.LookPath("session-manager-plugin")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant find the session manager plugin, install it from https://docs.aws.amazon.com/systems-manager/latest/userguide/session-manager-working-with-install-plugin.html: %v\n", err)
+		return 1
+	}
+
+	h := sfm.Handle{CFNcli: c.cfnc}
+	stack, err := h.Get(stackname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get stack: %v\n", err)
+		return 1
+	}
+	client := ecs.NewFromConfig(cfg)
+	svc, err := stackService(client, stack)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get service: %v\n", err)
+		return 1
+	}
+	t, err := execTask(client, svc, task)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant pick task: %v\n", err)
+		return 1
+	}
+	if !t.EnableExecuteCommand {
+		fmt.Fprintf(os.Stderr, "task %s wasn't started with ECS Exec enabled, set aws.ecs.enable_execute_command and deploy\n", lastSegment(aws.ToString(t.TaskArn), "/"))
+		return 1
+	}
+	runtimeID := ""
+	for _, ct := range t.Containers {
+		if aws.ToString(ct.Name) == container {
+			runtimeID = aws.ToString(ct.RuntimeId)
+		}
+	}
+	if runtimeID == "" {
+		fmt.Fprintf(os.Stderr, "container %s isn't running in task %s\n", container, lastSegment(aws.ToString(t.TaskArn), "/"))
+		return 1
+	}
+
+	command := execDefaultCommand
+	if len(cmd) > 0 {
+		command = strings.Join(cmd, " ")
+	}
+	out, err := client.ExecuteCommand(context.TODO(), &ecs.ExecuteCommandInput{
+		Cluster:     svc.ClusterArn,
+		Task:        t.TaskArn,
+		Container:   aws.String(container),
+		Command:     aws.String(command),
+		Interactive: true,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant execute command: %v\n", err)
+		return 1
+	}
+
+	session, err := json.Marshal(out.Session)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant marshal session: %v\n", err)
+		return 1
+	}
+	target, err := json.Marshal(map[string]string{
+		"Target": fmt.Sprintf("ecs:%s_%s_%s", lastSegment(aws.ToString(svc.ClusterArn), "/"), lastSegment(aws.ToString(t.TaskArn), "/"), runtimeID),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant marshal target: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Running %s in %s on task %s\n", command, container, lastSegment(aws.ToString(t.TaskArn), "/"))
+
+	// ctrl-c is for the command in the container, the plugin passes it on
+	signal.Ignore(os.Interrupt)
+	p := exec.Command(plugin, string(session), cfg.Region, "StartSession", os.Getenv("AWS_PROFILE"), string(target), fmt.Sprintf("https://ssm.%s.amazonaws.com", cfg.Region))
+	p.Stdin, p.Stdout, p.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := p.Run(); err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return ee.ExitCode()
+		}
+		fmt.Fprintf(os.Stderr, "cant run session manager plugin: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// execTask returns the service's running task with the id or arn given, or its number in the running
+// tasks yeet output running lists. Without one it's the first running task, the same as that list's #1.
+func execTask(client *ecs.Client, svc types.Service, task string) (types.Task, error) {
+	list, err := client.ListTasks(context.TODO(), &ecs.ListTasksInput{
+		Cluster:     svc.ClusterArn,
+		ServiceName: svc.ServiceName,
+	})
+	if err != nil {
+		return types.Task{}, fmt.Errorf("failed to get task ARNs: %v", err)
+	}
+	if len(list.TaskArns) < 1 {
+		return types.Task{}, fmt.Errorf("service %s has no running tasks", aws.ToString(svc.ServiceName))
+	}
+	tasks, err := client.DescribeTasks(context.TODO(), &ecs.DescribeTasksInput{
+		Cluster: svc.ClusterArn,
+		Tasks:   list.TaskArns,
+	})
+	if err != nil {
+		return types.Task{}, fmt.Errorf("failed to describe tasks: %v", err)
+	}
+	if task == "" {
+		for _, t := range tasks.Tasks {
+			if aws.ToString(t.LastStatus) == "RUNNING" {
+				return t, nil
+			}
+		}
+		return types.Task{}, fmt.Errorf("service %s has no tasks which have finished starting", aws.ToString(svc.ServiceName))
+	}
+	if n, err := strconv.Atoi(task); err == nil && n > 0 && n <= len(tasks.Tasks) {
+		return tasks.Tasks[n-1], nil
+	}
+	for _, t := range tasks.Tasks {
+		if aws.ToString(t.TaskArn) == task || lastSegment(aws.ToString(t.TaskArn), "/") == task {
+			return t, nil
+		}
+	}
+	return types.Task{}, fmt.Errorf("task %s isn't one of service %s's running tasks", task, aws.ToString(svc.ServiceName))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the listener rule keys which are conditions, ALBs allow a rule five condition values between them
+var ruleConditions = []string{"path", "hostname", "http_headers", "http_methods", "query_strings", "source_ips"}
+
+const ruleConditionValues = 5
+
+// asList returns a config value which can be one value or a list of them as a list
+func asList(input interface{}) []interface{} {
+	if input == nil {
+		return nil
+	}
+	if l, ok := input.([]interface{}); ok {
+		return l
+	}
+	return []interface{}{input}
+}
+
+// ruleValues returns a listener rule condition's values quoted for the template, a single value
+// on its own and more than one as a list
+func ruleValues(input interface{}) string {
+	l := asList(input)
+	if len(l) == 1 {
+		return quoteYAML(fmt.Sprint(l[0]))
+	}
+	ss := []string{}
+	for _, v := range l {
+		ss = append(ss, quoteYAML(fmt.Sprint(v)))
+	}
+	return "[" + strings.Join(ss, ", ") + "]"
+}
+
+// checkLoadBalancers reports ALBs whose target group yeet creates without a VPC to put it in,
+// ALB listener rules which have no listener to be added to, no conditions or more than ALBs
+// allow, or more than one action of a kind, and target groups which no rule forwards to
+func checkLoadBalancers(values map[string]interface{}) []problem {
+	pp := []problem{}
+	albs := assertMSI(getValue(values, "aws.application_load_balancers"))
+	novpc := isEmpty(getValue(values, "aws.vpc"))
+	for _, name := range sortedKeys(albs) {
+		alb := assertMSI(albs[name])
+		if novpc && alb["target_group"] == nil {
+			pp = append(pp, problem{[]string{"aws", "vpc"}, fmt.Sprintf("required when aws.application_load_balancers.%s has no target_group", name)})
+			novpc = false
+		}
+		rules := assertMSI(alb["listener_rules"])
+		forwards := false
+		for _, rule := range sortedKeys(rules) {
+			path := []string{"aws", "application_load_balancers", name, "listener_rules", rule}
+			r := assertMSI(rules[rule])
+			if _, ok := r["listener_arn"]; !ok && alb["load_balancer"] == nil {
+				pp = append(pp, problem{subPath(path, "listener_arn"), "required unless yeet creates the load balancer with load_balancer"})
+			}
+
+			n := 0
+			for _, c := range ruleConditions {
+				if m := assertMSI(r[c]); m != nil {
+					for _, k := range sortedKeys(m) {
+						n += len(asList(m[k]))
+					}
+					continue
+				}
+				n += len(asList(r[c]))
+			}
+			if n == 0 {
+				pp = append(pp, problem{path, "needs at least one of " + strings.Join(ruleConditions, ", ")})
+			}
+			if n > ruleConditionValues {
+				pp = append(pp, problem{path, fmt.Sprintf("has %d condition values, ALBs allow %d", n, ruleConditionValues)})
+			}
+
+			if r["fixed_response"] != nil && r["redirect"] != nil {
+				pp = append(pp, problem{path, "set either fixed_response or redirect, not both"})
+			}
+			if r["fixed_response"] == nil && r["redirect"] == nil {
+				forwards = true
+			}
+			if r["authenticate_oidc"] == nil && r["authenticate_cognito"] == nil {
+				continue
+			}
+			if r["authenticate_oidc"] != nil && r["authenticate_cognito"] != nil {
+				pp = append(pp, problem{path, "set either authenticate_oidc or authenticate_cognito, not both"})
+			}
+			if _, ok := r["listener_arn"]; !ok && alb["load_balancer"] != nil && getValue(alb, "load_balancer.certificates") == nil {
+				pp = append(pp, problem{path, "authenticating needs an HTTPS listener, set load_balancer.certificates"})
+			}
+		}
+		// ECS can only register tasks with a target group something forwards to
+		if len(rules) > 0 && !forwards && alb["load_balancer"] == nil && alb["target_group"] == nil {
+			pp = append(pp, problem{[]string{"aws", "application_load_balancers", name, "listener_rules"}, "at least one rule needs to forward to the target group, not respond or redirect"})
+		}
+	}
+	return pp
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/toolsdotgo/sfm/pkg/sfm"
+)
+
+// logsAPI is the part of the CloudWatch Logs client yeet uses, so a fake can stand in for it
+type logsAPI interface {
+	FilterLogEvents(ctx context.Context, params *cloudwatchlogs.FilterLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.FilterLogEventsOutput, error)
+}
+
+// how often logs -f polls for new log events
+var logsPollInterval = 2 * time.Second
+
+// logSource is where the awslogs driver sends a container's logs, in streams named prefix/container/task-id
+type logSource struct {
+	container string
+	group     string
+	prefix    string
+	region    string // the region of the log group, the client's own when empty
+	task      string // only this task's stream, when set
+}
+
+// logLine is a log event along with the container and task it came from
+type logLine struct {
+	container string
+	task      string
+	timestamp int64
+	message   string
+}
+
+func (c command) logsYeet(args []string, region string, container string, follow bool, since time.Duration, filter string) int {
+	values, err := readValues(defaults, args, region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read values: %v", err)
+		return 1
+	}
+	stackname, ok := values["name"].(string) // sorry
+	if !ok {
+		fmt.Fprintf(os.Stderr, "no stack name found")
+		return 1
+	}
+
+	h := sfm.Handle{CFNcli: c.cfnc}
+	stack, err := h.Get(stackname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get stack: %v\n", err)
+		return 1
+	}
+
+	sources, err := logSources(values, stack, container)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant find log groups: %v\n", err)
+		return 1
+	}
+
+	stop := func() bool { return !follow }
+	if err := tailLogs(logsClients(), sources, time.Now().Add(-since), filter, stop, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "cant get logs: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// logsClients returns a func giving a CloudWatch Logs client for a region, made once per region
+func logsClients() func(region string) logsAPI {
+	clients := map[string]logsAPI{}
+	return func(region string) logsAPI {
+		if _, ok := clients[region]; !ok {
+			clients[region] = cloudwatchlogs.NewFromConfig(cfg, func(o *cloudwatchlogs.Options) {
+				if region != "" {
+					o.Region = region
+				}
+			})
+		}
+		return clients[region]
+	}
+}
+
+// logSources returns the log group and stream prefix for each container in the config, or just the named one.
+// Containers without a log group of their own use the ServiceLogGroup created by the stack.
+func logSources(values map[string]interface{}, s sfm.Stack, container string) ([]logSource, error) {
+	containers := assertMSI(values["containers"])
+	if containers == nil {
+		return nil, fmt.Errorf("no containers in config")
+	}
+	names := []string{}
+	for name := range containers {
+		if container != "" && name != container {
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) < 1 {
+		return nil, fmt.Errorf("container %s not in config", container)
+	}
+	sort.Strings(names)
+
+	sources := []logSource{}
+	for _, name := range names {
+		src := logSource{container: name, prefix: name}
+		logs := assertMSI(assertMSI(containers[name])["logs"])
+		if g, ok := logs["group"]; ok {
+			src.group = fmt.Sprint(g)
+		}
+		if p, ok := logs["prefix"]; ok {
+			src.prefix = fmt.Sprint(p)
+		}
+		if r, ok := logs["region"]; ok {
+			src.region = fmt.Sprint(r)
+		}
+		if src.group == "" {
+			g, err := serviceLogGroup(s)
+			if err != nil {
+				return nil, err
+			}
+			src.group = g
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+// serviceLogGroup returns the name of the log group the stack created
+func serviceLogGroup(s sfm.Stack) (string, error) {
+	if g := s.Outputs["ServiceLogGroup"]; g != "" {
+		return g, nil
+	}
+	// stacks deployed before the output existed still have the resource
+	rr, err := s.Resources()
+	if err != nil {
+		return "", err
+	}
+	if r, ok := rr["ServiceLogGroup"]; ok {
+		return r["pid"], nil
+	}
+	return "", fmt.Errorf("stack %s has no ServiceLogGroup", s.Name)
+}
+
+// tailLogs writes the log events for every source from start onwards, interleaved by time, getting each
+// source's events with the client for its region. It keeps polling for new events until stop, which is
+// checked before each poll, returns true.
+func tailLogs(client func(region string) logsAPI, sources []logSource, start time.Time, filter string, stop func() bool, w io.Writer) error {
+	from := start.UnixMilli()
+	seen := map[string]int64{} // event ids already written, and their timestamps
+	for {
+		last := stop()
+		lines := []logLine{}
+		for _, src := range sources {
+			in := &cloudwatchlogs.FilterLogEventsInput{
+				LogGroupName:        aws.String(src.group),
+				LogStreamNamePrefix: aws.String(fmt.Sprintf("%s/%s/%s", src.prefix, src.container, src.task)),
+				StartTime:           aws.Int64(from),
+			}
+			if filter != "" {
+				in.FilterPattern = aws.String(filter)
+			}
+			for {
+				out, err := client(src.region).FilterLogEvents(context.TODO(), in)
+				if err != nil {
+					return fmt.Errorf("failed to filter %s: %v", src.group, err)
+				}
+				for _, e := range out.Events {
+					id := aws.ToString(e.EventId)
+					if _, ok := seen[id]; ok {
+						continue
+					}
+					seen[id] = aws.ToInt64(e.Timestamp)
+					lines = append(lines, logLine{
+						container: src.container,
+						task:      lastSegment(aws.ToString(e.LogStreamName), "/"),
+						timestamp: aws.ToInt64(e.Timestamp),
+						message:   strings.TrimRight(aws.ToString(e.Message), "\n"),
+					})
+				}
+				if out.NextToken == nil {
+					break
+				}
+				in.NextToken = out.NextToken
+			}
+		}
+
+		sort.SliceStable(lines, func(i, j int) bool { return lines[i].timestamp < lines[j].timestamp })
+		for _, l := range lines {
+			fmt.Fprintf(w, "[%s %s] %s\n", l.container, l.task, l.message)
+		}
+		if last {
+			return nil
+		}
+
+		// the next poll starts at the newest event, so only remember the events it could return again
+		if len(lines) > 0 {
+			from = lines[len(lines)-1].timestamp
+		}
+		for id, ts := range seen {
+			if ts < from {
+				delete(seen, id)
+			}
+		}
+		time.Sleep(logsPollInterval)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/toolsdotgo/sfm/pkg/sfm"
+)
+
+// fakeLogs is a CloudWatch Logs client with events in log groups, returned pageSize at a time
+type fakeLogs struct {
+	events   map[string][]types.FilteredLogEvent
+	pageSize int
+}
+
+func (f *fakeLogs) FilterLogEvents(ctx context.Context, in *cloudwatchlogs.FilterLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	matched := []types.FilteredLogEvent{}
+	for _, e := range f.events[aws.ToString(in.LogGroupName)] {
+		if strings.HasPrefix(aws.ToString(e.LogStreamName), aws.ToString(in.LogStreamNamePrefix)) && aws.ToInt64(e.Timestamp) >= aws.ToInt64(in.StartTime) {
+			matched = append(matched, e)
+		}
+	}
+	start := 0
+	if in.NextToken != nil {
+		start, _ = strconv.Atoi(*in.NextToken)
+	}
+	end := len(matched)
+	if f.pageSize > 0 && start+f.pageSize < end {
+		end = start + f.pageSize
+	}
+	out := &cloudwatchlogs.FilterLogEventsOutput{Events: matched[start:end]}
+	if end < len(matched) {
+		out.NextToken = aws.String(strconv.Itoa(end))
+	}
+	return out, nil
+}
+
+func event(id string, stream string, ts int64, msg string) types.FilteredLogEvent {
+	return types.FilteredLogEvent{
+		EventId:       aws.String(id),
+		LogStreamName: aws.String(stream),
+		Timestamp:     aws.Int64(ts),
+		Message:       aws.String(msg + "\n"),
+	}
+}
+
+// polls returns a stop func for tailLogs which stops after n polls, calling each before its poll
+func polls(n int, each func(poll int)) func() bool {
+	poll := 0
+	return func() bool {
+		poll++
+		if each != nil {
+			each(poll)
+		}
+		return poll >= n
+	}
+}
+
+func TestTailLogsInterleaves(t *testing.T) {
+	web := &fakeLogs{events: map[string][]types.FilteredLogEvent{
+		"app": {
+			event("w1", "web/web/t1", 100, "web one"),
+			event("w2", "web/web/t1", 300, "web two"),
+			event("x1", "other/other/t1", 150, "not this container"),
+		},
+	}}
+	worker := &fakeLogs{events: map[string][]types.FilteredLogEvent{
+		"jobs": {
+			event("k1", "jobs/worker/t2", 200, "worker one"),
+			event("k2", "jobs/worker/t2", 50, "too old"),
+		},
+	}}
+	clients := map[string]logsAPI{"": web, "us-east-1": worker}
+	sources := []logSource{
+		{container: "web", group: "app", prefix: "web"},
+		{container: "worker", group: "jobs", prefix: "jobs", region: "us-east-1"},
+	}
+
+	var w bytes.Buffer
+	client := func(region string) logsAPI { return clients[region] }
+	if err := tailLogs(client, sources, time.UnixMilli(100), "", polls(1, nil), &w); err != nil {
+		t.Fatal(err)
+	}
+	want := "[web t1] web one\n[worker t2] worker one\n[web t1] web two\n"
+	if w.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", w.String(), want)
+	}
+}
+
+func TestTailLogsPaginates(t *testing.T) {
+	client := &fakeLogs{pageSize: 1, events: map[string][]types.FilteredLogEvent{
+		"app": {
+			event("a", "web/web/t1", 100, "one"),
+			event("b", "web/web/t1", 101, "two"),
+			event("c", "web/web/t1", 102, "three"),
+		},
+	}}
+	sources := []logSource{{container: "web", group: "app", prefix: "web"}}
+
+	var w bytes.Buffer
+	if err := tailLogs(func(string) logsAPI { return client }, sources, time.UnixMilli(0), "", polls(1, nil), &w); err != nil {
+		t.Fatal(err)
+	}
+	want := "[web t1] one\n[web t1] two\n[web t1] three\n"
+	if w.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", w.String(), want)
+	}
+}
+
+func TestTailLogsDedupes(t *testing.T) {
+	defer func(d time.Duration) { logsPollInterval = d }(logsPollInterval)
+	logsPollInterval = 0
+
+	client := &fakeLogs{events: map[string][]types.FilteredLogEvent{
+		"app": {event("a", "web/web/t1", 100, "a")},
+	}}
+	sources := []logSource{{container: "web", group: "app", prefix: "web"}}
+	// events arrive at the same millisecond as ones already written, then after them
+	more := func(poll int) {
+		switch poll {
+		case 2:
+			client.events["app"] = append(client.events["app"], event("b", "web/web/t1", 100, "b"))
+		case 4:
+			client.events["app"] = append(client.events["app"], event("c", "web/web/t1", 200, "c"))
+		}
+	}
+
+	var w bytes.Buffer
+	if err := tailLogs(func(string) logsAPI { return client }, sources, time.UnixMilli(0), "", polls(5, more), &w); err != nil {
+		t.Fatal(err)
+	}
+	want := "[web t1] a\n[web t1] b\n[web t1] c\n"
+	if w.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", w.String(), want)
+	}
+}
+
+func TestLogSources(t *testing.T) {
+	values := map[string]interface{}{
+		"containers": map[interface{}]interface{}{
+			"web": map[interface{}]interface{}{},
+			"worker": map[interface{}]interface{}{
+				"logs": map[interface{}]interface{}{"group": "jobs", "prefix": "jobs", "region": "us-east-1"},
+			},
+		},
+	}
+	stack := sfm.Stack{Outputs: map[string]string{"ServiceLogGroup": "app"}}
+
+	got, err := logSources(values, stack, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []logSource{
+		{container: "web", group: "app", prefix: "web"},
+		{container: "worker", group: "jobs", prefix: "jobs", region: "us-east-1"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("source %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := logSources(values, stack, "missing"); err == nil {
+		t.Error("expected an error for a container not in the config")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	_ "embed"
@@ -43,7 +44,7 @@ var c command
 
 type command struct {
 	cfnc *cloudformation.Client // cloudformation client
-	ssmc *ssm.Client            // ssm client
+	ssmc ssmAPI                 // ssm client, or fixtures standing in for it
 }
 
 func main() {
@@ -51,6 +52,7 @@ func main() {
 	fhelp := flag.Bool("h", false, "show help")
 	fver := flag.Bool("v", false, "show version")
 	freg := flag.String("r", "", "set aws region")
+	fssmFixtures := flag.String("ssm-fixtures", "", "yaml file of ssm param values to use instead of ssm")
 	flag.BoolVar(&bk, "bk", false, "force running as though in Buildkite")
 
 	flag.Parse()
@@ -59,10 +61,67 @@ func main() {
 	fsDeploy := flag.NewFlagSet("deploy", flag.ExitOnError)
 	fDeployHelp := fsDeploy.Bool("h", false, "show help for deploy")
 	fDeployTagsfile := fsDeploy.String("tf", "", "tag file for CloudFormation Stack")
+	fDeployConfirm := fsDeploy.Bool("confirm", false, "create a change set and prompt before applying it")
+	fDeployChangesetOnly := fsDeploy.Bool("changeset-only", false, "create a change set without applying it")
+	fDeployExecute := fsDeploy.String("execute", "", "apply a change set created with -changeset-only")
+	fDeploySkipValidate := fsDeploy.Bool("skip-validate", false, "deploy without validating the config")
+
+	// yeet diff [param_files ...]
+	fsDiff := flag.NewFlagSet("diff", flag.ExitOnError)
+	fDiffHelp := fsDiff.Bool("h", false, "show help for diff")
+	fDiffTagsfile := fsDiff.String("tf", "", "tag file for CloudFormation Stack")
+
+	// yeet destroy [param_files ...]
+	fsDestroy := flag.NewFlagSet("destroy", flag.ExitOnError)
+	fDestroyHelp := fsDestroy.Bool("h", false, "show help for destroy")
+	fDestroyForce := fsDestroy.Bool("force", false, "destroy without typing the stack name to confirm")
+
+	// yeet rollback [param_files ...]
+	fsRollback := flag.NewFlagSet("rollback", flag.ExitOnError)
+	fRollbackHelp := fsRollback.Bool("h", false, "show help for rollback")
+	fRollbackTo := fsRollback.Int("to", 0, "task definition revision to roll back to")
+	fRollbackList := fsRollback.Bool("list", false, "list task definition revisions without rolling back")
+	fRollbackTagsfile := fsRollback.String("tf", "", "tag file for CloudFormation Stack")
+
+	// yeet status [param_files ...]
+	fsStatus := flag.NewFlagSet("status", flag.ExitOnError)
+	fStatusHelp := fsStatus.Bool("h", false, "show help for status")
+	fStatusEvents := fsStatus.Int("n", 10, "number of service events to show")
+
+	// yeet logs [param_files ...]
+	fsLogs := flag.NewFlagSet("logs", flag.ExitOnError)
+	fLogsHelp := fsLogs.Bool("h", false, "show help for logs")
+	fLogsContainer := fsLogs.String("c", "", "only show logs for this container")
+	fLogsFollow := fsLogs.Bool("f", false, "keep polling for new log events")
+	fLogsSince := fsLogs.Duration("since", 10*time.Minute, "show log events newer than this")
+	fLogsFilter := fsLogs.String("filter", "", "CloudWatch Logs filter pattern")
+
+	// yeet run [param_files ...] [-- command ...]
+	fsRun := flag.NewFlagSet("run", flag.ExitOnError)
+	fRunHelp := fsRun.Bool("h", false, "show help for run")
+	fRunContainer := fsRun.String("c", "", "container to run the command in")
+
+	// yeet exec [param_files ...] [-- command ...]
+	fsExec := flag.NewFlagSet("exec", flag.ExitOnError)
+	fExecHelp := fsExec.Bool("h", false, "show help for exec")
+	fExecTask := fsExec.String("task", "", "task id, or its number in output running, to run the command in")
+	fExecContainer := fsExec.String("c", "", "container to run the command in")
+
+	// yeet canary [promote|abort] [param_files ...]
+	fsCanary := flag.NewFlagSet("canary", flag.ExitOnError)
+	fCanaryHelp := fsCanary.Bool("h", false, "show help for canary")
+	fCanaryWeight := fsCanary.Int("weight", 10, "percent of requests to send to the canary")
+	fCanaryTagsfile := fsCanary.String("tf", "", "tag file for CloudFormation Stack")
+
+	// yeet validate [param_files ...]
+	fsValidate := flag.NewFlagSet("validate", flag.ExitOnError)
+	fValidateHelp := fsValidate.Bool("h", false, "show help for validate")
 
 	// yeet output [subcommand]
 	fsOutput := flag.NewFlagSet("output", flag.ExitOnError)
 	fOutputHelp := fsOutput.Bool("h", false, "show help for output")
+	fsOutputInputs := flag.NewFlagSet("inputs", flag.ExitOnError)
+	fOutputInputsExplain := fsOutputInputs.Bool("explain", false, "show where each value came from")
 
 	if *fver {
 		fmt.Println(version, platform)
@@ -79,36 +138,90 @@ func main() {
 	if region == "" {
 		region = os.Getenv("AWS_DEFAULT_REGION")
 	}
-	if region == "" {
+	// rendering and validating config only needs aws for ssm params, so they can run offline
+	offline := flag.Arg(0) == "validate" || (flag.Arg(0) == "output" && (flag.Arg(1) == "template" || flag.Arg(1) == "inputs"))
+	// fixtures stand in for ssm when rendering, deploying with them would deploy made up values
+	if *fssmFixtures != "" && !offline {
+		fmt.Fprintln(os.Stderr, "-ssm-fixtures only works with output template, output inputs and validate")
+		os.Exit(64)
+	}
+	if region == "" && !offline {
 		fmt.Fprintln(os.Stderr, "no region set - as flag or env var")
 		os.Exit(1)
 	}
 
 	var err error
-	cfg, err = config.LoadDefaultConfig(
-		context.TODO(),
-		config.WithRegion(region),
-		config.WithRetryer(func() aws.Retryer {
-			retryer := retry.AddWithMaxAttempts(retry.NewStandard(), 10)
-			return retry.AddWithMaxBackoffDelay(retryer, 30*time.Second)
-		}),
-	)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "cant get aws config: %v\n", err)
-		os.Exit(1)
+	// offline sub-commands given fixtures don't touch aws at all, so they can't wait on credentials
+	if region != "" && *fssmFixtures == "" {
+		cfg, err = config.LoadDefaultConfig(
+			context.TODO(),
+			config.WithRegion(region),
+			config.WithRetryer(func() aws.Retryer {
+				retryer := retry.AddWithMaxAttempts(retry.NewStandard(), 10)
+				return retry.AddWithMaxBackoffDelay(retryer, 30*time.Second)
+			}),
+		)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cant get aws config: %v\n", err)
+			os.Exit(1)
+		}
+		c.cfnc = cloudformation.NewFromConfig(cfg)
+	}
+	switch {
+	case *fssmFixtures != "":
+		// only offline sub-commands get here with fixtures, which are used whatever the region
+		c.ssmc, err = loadSSMFixtures(*fssmFixtures)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cant load ssm fixtures: %v\n", err)
+			os.Exit(1)
+		}
+	case region != "":
+		c.ssmc = ssm.NewFromConfig(cfg)
+	default:
+		c.ssmc = noSSM{}
 	}
-	c.cfnc = cloudformation.NewFromConfig(cfg)
-	c.ssmc = ssm.NewFromConfig(cfg)
 
 	if os.Getenv("BUILDKITE") == "true" {
 		bk = true
 	}
 
+	// the config files, which every sub-command takes flags before or after
+	var files []string
 	switch flag.Arg(0) {
 	case "deploy":
-		_ = fsDeploy.Parse(flag.Args()[1:])
+		files = parseArgs(fsDeploy, flag.Args()[1:])
+	case "diff":
+		files = parseArgs(fsDiff, flag.Args()[1:])
+	case "destroy":
+		files = parseArgs(fsDestroy, flag.Args()[1:])
+	case "rollback":
+		files = parseArgs(fsRollback, flag.Args()[1:])
+	case "status":
+		files = parseArgs(fsStatus, flag.Args()[1:])
+	case "logs":
+		files = parseArgs(fsLogs, flag.Args()[1:])
+	case "run":
+		files = parseArgs(fsRun, flag.Args()[1:])
+	case "exec":
+		files = parseArgs(fsExec, flag.Args()[1:])
+	case "canary":
+		if flag.Arg(1) == "promote" || flag.Arg(1) == "abort" {
+			files = parseArgs(fsCanary, flag.Args()[2:])
+			break
+		}
+		files = parseArgs(fsCanary, flag.Args()[1:])
+	case "validate":
+		files = parseArgs(fsValidate, flag.Args()[1:])
 	case "output":
+		// output's own flags come before what to output, whose flags are parsed along with the config files
 		_ = fsOutput.Parse(flag.Args()[1:])
+		fs := fsOutput
+		if flag.Arg(1) == "inputs" {
+			fs = fsOutputInputs
+		}
+		if flag.NArg() > 2 {
+			files = parseArgs(fs, flag.Args()[2:])
+		}
 	default:
 		fmt.Fprintf(os.Stderr, "unknown subcommand '%s'\n", flag.Arg(0))
 		fmt.Print(usageTop)
@@ -120,7 +233,84 @@ func main() {
 			fmt.Print(usageDeploy)
 			os.Exit(64)
 		}
-		os.Exit(c.deployYeet(fsDeploy.Args(), region, *fDeployTagsfile))
+		os.Exit(c.deployYeet(files, region, deployFlags{
+			tagsfile:      *fDeployTagsfile,
+			confirm:       *fDeployConfirm,
+			changesetOnly: *fDeployChangesetOnly,
+			execute:       *fDeployExecute,
+			skipValidate:  *fDeploySkipValidate,
+		}))
+	}
+	if fsDiff.Parsed() {
+		if *fDiffHelp {
+			fmt.Print(usageDiff)
+			os.Exit(64)
+		}
+		os.Exit(c.diffYeet(files, region, *fDiffTagsfile))
+	}
+	if fsDestroy.Parsed() {
+		if *fDestroyHelp {
+			fmt.Print(usageDestroy)
+			os.Exit(64)
+		}
+		os.Exit(c.destroyYeet(files, region, *fDestroyForce))
+	}
+	if fsRollback.Parsed() {
+		if *fRollbackHelp {
+			fmt.Print(usageRollback)
+			os.Exit(64)
+		}
+		os.Exit(c.rollbackYeet(files, region, *fRollbackTo, *fRollbackList, *fRollbackTagsfile))
+	}
+	if fsStatus.Parsed() {
+		if *fStatusHelp {
+			fmt.Print(usageStatus)
+			os.Exit(64)
+		}
+		os.Exit(c.statusYeet(files, region, *fStatusEvents))
+	}
+	if fsLogs.Parsed() {
+		if *fLogsHelp {
+			fmt.Print(usageLogs)
+			os.Exit(64)
+		}
+		os.Exit(c.logsYeet(files, region, *fLogsContainer, *fLogsFollow, *fLogsSince, *fLogsFilter))
+	}
+	if fsRun.Parsed() {
+		if *fRunHelp {
+			fmt.Print(usageRun)
+			os.Exit(64)
+		}
+		os.Exit(c.runYeet(files, region, *fRunContainer))
+	}
+	if fsExec.Parsed() {
+		if *fExecHelp {
+			fmt.Print(usageExec)
+			os.Exit(64)
+		}
+		os.Exit(c.execYeet(files, region, *fExecTask, *fExecContainer))
+	}
+	if fsCanary.Parsed() {
+		if *fCanaryHelp {
+			fmt.Print(usageCanary)
+			os.Exit(64)
+		}
+		if *fCanaryWeight < 0 || *fCanaryWeight > 100 {
+			fmt.Fprintf(os.Stderr, "-weight must be between 0 and 100, not %d\n", *fCanaryWeight)
+			os.Exit(64)
+		}
+		action := ""
+		if flag.Arg(1) == "promote" || flag.Arg(1) == "abort" {
+			action = flag.Arg(1)
+		}
+		os.Exit(c.canaryYeet(action, files, region, *fCanaryWeight, *fCanaryTagsfile))
+	}
+	if fsValidate.Parsed() {
+		if *fValidateHelp {
+			fmt.Print(usageValidate)
+			os.Exit(64)
+		}
+		os.Exit(c.validateYeet(files, region))
 	}
 	if fsOutput.Parsed() {
 		if *fOutputHelp {
@@ -129,18 +319,25 @@ func main() {
 		}
 		switch flag.Arg(1) {
 		case "template":
-			tpl, err := generateTemplate(ecstpl, defaults, flag.Args()[2:], region)
+			tpl, err := generateTemplate(ecstpl, defaults, files, region)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "failed generate template: %v", err)
 				os.Exit(1)
 			}
 			fmt.Println(tpl)
 		case "inputs":
-			values, err := readValues(defaults, flag.Args()[2:], region)
+			values, org, err := readValuesWithOrigins(defaults, files, region)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "failed to read values: %v", err)
 				os.Exit(1)
 			}
+			if *fOutputInputsExplain {
+				if err := printOrigins(os.Stdout, values, org); err != nil {
+					fmt.Fprintf(os.Stderr, "failed to explain inputs: %v", err)
+					os.Exit(1)
+				}
+				os.Exit(0)
+			}
 			bb, err := yaml.Marshal(values)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "failed to marshal inputs: %v", err)
@@ -148,7 +345,7 @@ func main() {
 			}
 			fmt.Println(string(bb))
 		case "running":
-			values, err := readValues(defaults, flag.Args()[2:], region)
+			values, err := readValues(defaults, files, region)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "failed to read values: %v", err)
 				os.Exit(1)
@@ -180,26 +377,95 @@ func main() {
 	}
 }
 
-func (c command) deployYeet(args []string, region string, tagsfile string) int {
-	template, err := generateTemplate(ecstpl, defaults, args, region)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed generate template: %v", err)
-		return 1
+// parseArgs parses fs from args where flags can come before or after the config files, and returns
+// the config files. Everything from a -- on is returned after them untouched, for commands run in containers.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	tail := []string{}
+	for i, a := range args {
+		if a == "--" {
+			args, tail = args[:i], args[i:]
+			break
+		}
 	}
+	files := []string{}
+	for {
+		_ = fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return append(files, tail...)
+		}
+		files = append(files, args[0])
+		args = args[1:]
+	}
+}
 
-	values, err := readValues(defaults, args, region)
+// deployFlags are the options for a deploy beyond the config files
+type deployFlags struct {
+	tagsfile      string // tag file for the stack
+	confirm       bool   // prompt before applying a change set
+	changesetOnly bool   // stop once the change set is created
+	execute       string // apply a previously created change set
+	skipValidate  bool   // deploy config which doesn't match the schema
+	promote       bool   // deploy while the stack's canary takes its requests, as canary promote does
+}
+
+func (c command) deployYeet(args []string, region string, df deployFlags) int {
+	values, org, err := readValuesWithOrigins(defaults, args, region)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to read values: %v", err)
 		return 1
 	}
+	if !df.skipValidate {
+		pp, err := validateValues(values)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cant validate config: %v\n", err)
+			return 1
+		}
+		if len(pp) > 0 {
+			printProblems(pp, org)
+			fmt.Fprintln(os.Stderr, "not deploying invalid config, use -skip-validate to deploy it anyway")
+			return 1
+		}
+		checkTaskRole(values, os.Stderr)
+		if pp := checkImagePlatforms(values, os.Stderr); len(pp) > 0 {
+			for _, p := range pp {
+				fmt.Fprintln(os.Stderr, p)
+			}
+			fmt.Fprintln(os.Stderr, "not deploying images which cant run on the task's platform, use -skip-validate to deploy them anyway")
+			return 1
+		}
+	}
+	return c.deployValues(values, args, df)
+}
+
+// deployValues deploys the stack rendered from already read values
+func (c command) deployValues(values map[string]interface{}, args []string, df deployFlags) int {
 	stackname, ok := values["name"].(string) // sorry
 	if !ok {
 		fmt.Fprintf(os.Stderr, "no stack name found")
 		return 1
 	}
 
+	cluster := ""
+	if v := getValue(values, "aws.ecs.cluster"); v != nil {
+		cluster = fmt.Sprint(v)
+	}
+
 	h := sfm.Handle{CFNcli: c.cfnc}
 	stack := h.NewStack(stackname)
+	// a canary's weights are set on the stack's listeners and rules outside CloudFormation,
+	// which a deploy would neither see nor put back
+	if !df.promote {
+		if canary := h.NewStack(stackname + canarySuffix); !canary.Created.IsZero() {
+			fmt.Fprintf(os.Stderr, "canary stack %s exists, run yeet canary promote or abort before deploying\n", canary.Name)
+			return 1
+		}
+	}
+	template, err := stackTemplate(values, stack)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed generate template: %v", err)
+		return 1
+	}
 
 	if !stack.Created.IsZero() {
 		if bk {
@@ -212,8 +478,8 @@ func (c command) deployYeet(args []string, region string, tagsfile string) int {
 	}
 	fmt.Println()
 
-	if bk {
-		fmt.Println("+++ Deploying Yeet Stack")
+	if df.execute != "" {
+		return c.executeYeet(h, stackname, df.execute, cluster)
 	}
 
 	if err := stack.NewTemplate([]byte(template)); err != nil {
@@ -221,19 +487,34 @@ func (c command) deployYeet(args []string, region string, tagsfile string) int {
 		return 1
 	}
 
-	stack.Tags, err = loadTags(tagsfile)
+	stack.Tags, err = loadTags(df.tagsfile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "cant load tags: %v", err)
 		return 1
 	}
 
-	timeout := 60 * time.Minute
+	if df.confirm || df.changesetOnly {
+		return c.changeSetYeet(h, stack, args, df, cluster)
+	}
+
+	if bk {
+		fmt.Println("+++ Deploying Yeet Stack")
+	}
+
 	token, err := h.Make(stack)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to make stack: %v", err)
 		return 1
 	}
 
+	return waitDeploy(h, stackname, token, cluster)
+}
+
+// waitDeploy streams stack events for the request token along with what the service in the cluster is doing
+// until the stack settles, then describes the service
+func waitDeploy(h sfm.Handle, stackname string, token string, cluster string) int {
+	timeout := 60 * time.Minute
+	watch := newServiceWatcher(cluster)
 	id := ""
 	for start := time.Now(); time.Since(start) < timeout; {
 		s, err := h.Get(stackname)
@@ -251,6 +532,11 @@ func (c command) deployYeet(args []string, region string, tagsfile string) int {
 			id = e.ID
 		}
 		if s.Short == "ok" {
+			rc := 0
+			if s.Outputs["CodeDeployDeploymentGroup"] != "" {
+				// the stack only has the new task definition, CodeDeploy moves the service on to it
+				rc = deployBlueGreen(s, watch)
+			}
 			if bk {
 				fmt.Println("+++ Describe running ECS Tasks after deployment")
 			}
@@ -258,7 +544,7 @@ func (c command) deployYeet(args []string, region string, tagsfile string) int {
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "cant describe service post-update: %v", err)
 			}
-			return 0
+			return rc
 		}
 		if s.Short == "err" {
 			fmt.Fprintf(os.Stderr, "stack in err state: %v\n", s.Status)
@@ -271,13 +557,14 @@ func (c command) deployYeet(args []string, region string, tagsfile string) int {
 			}
 			return 1
 		}
+		watch.poll(s)
 		time.Sleep(2 * time.Second)
 	}
 	fmt.Fprintf(os.Stderr, "stack operation wait timed out, took longer than %s\n", timeout)
 	if bk {
 		fmt.Println("+++ Describe running ECS Tasks after timedout deployment")
 	}
-	stack, err = h.Get(stackname)
+	stack, err := h.Get(stackname)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "cant get stack: %v", err)
 		return 1
@@ -334,8 +621,8 @@ func describeService(s sfm.Stack) (string, error) {
 	}
 	taskDef := make(map[string]string)
 	fmt.Println("Running Tasks:")
-	fmt.Println(" #   | Task ID                          | Task Version                        | Created at")
-	fmt.Println("-----+----------------------------------+-------------------------------------+-----------------------------------")
+	fmt.Println(" #   | Task ID                          | Task Version                        | Capacity     | Created at")
+	fmt.Println("-----+----------------------------------+-------------------------------------+--------------+-----------------------------------")
 	for i, t := range tasks.Tasks {
 		p := strings.LastIndex(*t.TaskArn, "/")
 		arn := *t.TaskArn
@@ -349,8 +636,13 @@ func describeService(s sfm.Stack) (string, error) {
 		if *t.LastStatus != "PROVISIONING" {
 			s = t.CreatedAt.In(loc).String()
 		}
+		// tasks placed by a capacity provider strategy don't have a launch type of their own
+		capacity := string(t.LaunchType)
+		if t.CapacityProviderName != nil {
+			capacity = *t.CapacityProviderName
+		}
 		c := fmt.Sprintf("%v", i+1)
-		fmt.Printf(" %3.3s | %s | %-35s | %s\n", c, id, vers, s)
+		fmt.Printf(" %3.3s | %s | %-35s | %-12s | %s\n", c, id, vers, capacity, s)
 	}
 	fmt.Println()
 	fmt.Println("Active Task Definitions:")
@@ -380,7 +672,40 @@ func describeService(s sfm.Stack) (string, error) {
 	return "", nil
 }
 
+// logicalID joins the inputs into a CloudFormation logical id, dropping anything that isn't alphanumeric
+func logicalID(input ...string) string {
+	var s string
+	var re = regexp.MustCompile("[^A-Za-z0-9]+")
+	for _, i := range input {
+		s = fmt.Sprintf("%s%s", s, re.ReplaceAllString(i, ""))
+	}
+	return s
+}
+
+// quoteYAML returns s as a single quoted yaml string, for values put in the template as they are
+func quoteYAML(s string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(s, "'", "''"))
+}
+
 func generateTemplate(tpl_string string, defaults string, param_files []string, region string) (string, error) {
+	values, err := readValues(defaults, param_files, region)
+	if err != nil {
+		return "", fmt.Errorf("failed to read values: %v", err)
+	}
+	return renderTemplate(tpl_string, values)
+}
+
+// stackTemplate renders the stack's template from values, keeping what only CodeDeploy moves on where
+// it is in the stack, so the template only changes what a deploy of it changes
+func stackTemplate(values map[string]interface{}, s sfm.Stack) (string, error) {
+	pinServiceTaskDefinition(values, s)
+	if err := pinLiveTargetGroup(values, s); err != nil {
+		return "", fmt.Errorf("cant find the target group CodeDeploy moved traffic to: %v", err)
+	}
+	return renderTemplate(ecstpl, values)
+}
+
+func renderTemplate(tpl_string string, values map[string]interface{}) (string, error) {
 	funcMap := template.FuncMap{
 		"add": func(i int, b int) int {
 			return i + b
@@ -404,14 +729,7 @@ func generateTemplate(tpl_string string, defaults string, param_files []string,
 			s := strings.ReplaceAll(input, " ", "")
 			return s
 		},
-		"logicalid": func(input ...string) string {
-			var s string
-			var re = regexp.MustCompile("[^A-Za-z0-9]+")
-			for _, i := range input {
-				s = fmt.Sprintf("%s%s", s, re.ReplaceAllString(i, ""))
-			}
-			return s
-		},
+		"logicalid": logicalID,
 		"titlecase": func(input string) string {
 			if strings.ToLower(input) == "allow" {
 				return "Allow"
@@ -437,6 +755,46 @@ func generateTemplate(tpl_string string, defaults string, param_files []string,
 		"contains": func(s, substr string) bool {
 			return strings.Contains(s, substr)
 		},
+		"list": func(items ...interface{}) []interface{} {
+			return items
+		},
+		"secretfrom": func(input interface{}) string {
+			from, _ := secretRef(input)
+			return from
+		},
+		"secretarn": func(input interface{}) string {
+			_, arn := secretRef(input)
+			return arn
+		},
+		"trackedmetric": func(input interface{}) string {
+			return trackedMetrics[fmt.Sprint(input)]
+		},
+		"albrule":            albRule,
+		"alblabel":           albLabel,
+		"queuearn":           queueARN,
+		"taskoverrides":      taskOverrides,
+		"aslist":             asList,
+		"rulevalues":         ruleValues,
+		"serviceconnectport": serviceConnectPort,
+		"sgpeer":             sgPeer,
+		// the distinct EFS security groups of the volumes, which only need the NFS rules once
+		"efssecuritygroups": func(input interface{}) []string {
+			sgs := []string{}
+			seen := map[string]bool{}
+			volumes := assertMSI(input)
+			for _, k := range sortedKeys(volumes) {
+				sg := assertMSI(assertMSI(volumes[k])["efs"])["security_group"]
+				if sg == nil || seen[fmt.Sprint(sg)] {
+					continue
+				}
+				seen[fmt.Sprint(sg)] = true
+				sgs = append(sgs, fmt.Sprint(sg))
+			}
+			return sgs
+		},
+		"quote": func(input interface{}) string {
+			return quoteYAML(fmt.Sprint(input))
+		},
 	}
 
 	tpl, err := template.New("ecs").Option("missingkey=zero").Funcs(funcMap).Parse(tpl_string)
@@ -444,11 +802,6 @@ func generateTemplate(tpl_string string, defaults string, param_files []string,
 		return "", fmt.Errorf("error parsing template: %v", err)
 	}
 
-	values, err := readValues(defaults, param_files, region)
-	if err != nil {
-		return "", fmt.Errorf("failed to read values: %v", err)
-	}
-
 	buf := new(bytes.Buffer)
 	err = tpl.Execute(buf, values)
 	if err != nil {
@@ -463,65 +816,75 @@ func generateTemplate(tpl_string string, defaults string, param_files []string,
 }
 
 func readValues(defaults string, filenames []string, region string) (map[string]interface{}, error) {
+	resultMap, _, err := readValuesWithOrigins(defaults, filenames, region)
+	return resultMap, err
+}
+
+// readValuesWithOrigins reads the values like readValues and also returns where each of them came from
+func readValuesWithOrigins(defaults string, filenames []string, region string) (map[string]interface{}, origins, error) {
 	// initialise some variables
 	resultMap := make(map[string]interface{})
+	org := origins{}
 	var defaultMap map[string]interface{}
 	var err error
 
 	// load the defaults in
 	if err := yaml.Unmarshal([]byte(defaults), &defaultMap); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal yaml: %v", err)
+		return nil, nil, fmt.Errorf("failed to unmarshal yaml: %v", err)
 	}
 
-	resultMap, err = loadFiles(resultMap, filenames)
+	resultMap, err = loadFiles(resultMap, filenames, org)
 	if err != nil {
-		return nil, fmt.Errorf("unable to load config files: %v", err)
+		return nil, nil, fmt.Errorf("unable to load config files: %v", err)
 	}
 
-	resultMap, err = loadIncludes(resultMap)
+	resultMap, err = loadIncludes(resultMap, org)
 	if err != nil {
-		return nil, fmt.Errorf("unable to load _includes: %v", err)
+		return nil, nil, fmt.Errorf("unable to load _includes: %v", err)
 	}
 
 	if awsConfig, ok := resultMap["aws"]; ok {
 		awsConfig := assertMSI(awsConfig)
 		if awsConfig == nil {
-			return nil, fmt.Errorf("Yeet's `.aws` config key seems malformed")
+			return nil, nil, fmt.Errorf("Yeet's `.aws` config key seems malformed")
 		}
-		if _, ok := awsConfig["region"]; !ok {
+		if _, ok := awsConfig["region"]; !ok && region != "" {
 			awsregionMap := map[string]interface{}{
 				"aws": map[string]interface{}{
 					"region": region,
 				},
 			}
+			org.record(resultMap, awsregionMap, "region from -r, AWS_REGION or AWS_DEFAULT_REGION", nil)
 			resultMap, err = mergeKeys(resultMap, awsregionMap)
 			if err != nil {
-				return nil, fmt.Errorf("unable to merge aws region in to config: %v", err)
+				return nil, nil, fmt.Errorf("unable to merge aws region in to config: %v", err)
 			}
 		}
 	}
 
+	org.record(resultMap, defaultMap, "built-in default.yml", []byte(defaults))
 	resultMap, err = mergeKeys(resultMap, defaultMap)
 	if err != nil {
-		return nil, fmt.Errorf("unable to merge yeet defaults: %v", err)
+		return nil, nil, fmt.Errorf("unable to merge yeet defaults: %v", err)
 	}
 
+	org.templated(resultMap, nil)
 	resultMap, err = templateConfig(resultMap)
 	if err != nil {
-		return nil, fmt.Errorf("unable to template config: %v", err)
+		return nil, nil, fmt.Errorf("unable to template config: %v", err)
 	}
 
 	resultMap, err = defaultKeys(resultMap)
 	if err != nil {
-		return nil, fmt.Errorf("unable to load default keys: %v", err)
+		return nil, nil, fmt.Errorf("unable to load default keys: %v", err)
 	}
 
 	resultMap = deleteNulls(resultMap)
 
-	return resultMap, nil
+	return resultMap, org, nil
 }
 
-func loadFiles(resultMap map[string]interface{}, filenames []string) (map[string]interface{}, error) {
+func loadFiles(resultMap map[string]interface{}, filenames []string, org origins) (map[string]interface{}, error) {
 	for _, f := range filenames {
 		var fileValues map[string]interface{}
 		bs, err := os.ReadFile(filepath.Clean(f))
@@ -531,6 +894,7 @@ func loadFiles(resultMap map[string]interface{}, filenames []string) (map[string
 		if err := yaml.Unmarshal(bs, &fileValues); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal yaml: %v", err)
 		}
+		org.record(resultMap, fileValues, f, bs)
 		resultMap, err = mergeKeys(resultMap, fileValues)
 		if err != nil {
 			return nil, fmt.Errorf("unable to merge config files: %v", err)
@@ -539,7 +903,7 @@ func loadFiles(resultMap map[string]interface{}, filenames []string) (map[string
 	return resultMap, nil
 }
 
-func loadSSM(resultMap map[string]interface{}, param string) (map[string]interface{}, error) {
+func loadSSM(resultMap map[string]interface{}, param string, org origins) (map[string]interface{}, error) {
 	ssmparam, err := c.ssmc.GetParameter(
 		context.TODO(),
 		&ssm.GetParameterInput{
@@ -555,6 +919,7 @@ func loadSSM(resultMap map[string]interface{}, param string) (map[string]interfa
 		return nil, fmt.Errorf("failed to unmarshal ssm param %v yaml: %v", param, err)
 	}
 
+	org.record(resultMap, pm, "ssm://"+param, []byte(*ssmparam.Parameter.Value))
 	resultMap, err = mergeKeys(resultMap, pm)
 	if err != nil {
 		return nil, fmt.Errorf("unable to merge ssm param %v: %v", param, err)
@@ -693,6 +1058,47 @@ func assertMSI(m interface{}) map[string]interface{} {
 	}
 }
 
+// Get the value at the dot separated path in config, or nil if it isn't set
+func getValue(config map[string]interface{}, path string) interface{} {
+	var v interface{} = config
+	for _, k := range strings.Split(path, ".") {
+		m := assertMSI(v)
+		if m == nil {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// Set the value at the dot separated path in config, creating any maps along the way
+func setValue(config map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys[:len(keys)-1] {
+		next := assertMSI(config[k])
+		if next == nil {
+			next = make(map[string]interface{})
+		}
+		config[k] = next
+		config = next
+	}
+	config[keys[len(keys)-1]] = value
+}
+
+// deleteValue removes the value at the dot separated path in config, if it's set
+func deleteValue(config map[string]interface{}, path string) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys[:len(keys)-1] {
+		next := assertMSI(config[k])
+		if next == nil {
+			return
+		}
+		config[k] = next
+		config = next
+	}
+	delete(config, keys[len(keys)-1])
+}
+
 // Delete keys who's value is null
 func deleteNulls(config map[string]interface{}) map[string]interface{} {
 	for k, v := range config {
@@ -754,7 +1160,7 @@ func templateConfig(config map[string]interface{}) (map[string]interface{}, erro
 				}
 				return strings.Join(ss, sep)
 			},
-			"ssm": func(param string) string {
+			"ssm": func(param string) (string, error) {
 				ssmparam, err := c.ssmc.GetParameter(
 					context.TODO(),
 					&ssm.GetParameterInput{
@@ -763,10 +1169,9 @@ func templateConfig(config map[string]interface{}) (map[string]interface{}, erro
 					},
 				)
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "unable to get param %v: %v", param, err)
-					panic("unable to get param value")
+					return "", fmt.Errorf("unable to get param %v: %v", param, err)
 				}
-				return *ssmparam.Parameter.Value
+				return *ssmparam.Parameter.Value, nil
 			},
 		}
 
@@ -807,7 +1212,7 @@ func assertSS(i interface{}) ([]string, error) {
 	}
 }
 
-func loadIncludes(config map[string]interface{}) (map[string]interface{}, error) {
+func loadIncludes(config map[string]interface{}, org origins) (map[string]interface{}, error) {
 	if config["_include"] == nil {
 		return config, nil
 	}
@@ -826,13 +1231,13 @@ func loadIncludes(config map[string]interface{}) (map[string]interface{}, error)
 			}
 			newIncludes = true
 			if len(inc) >= 6 && strings.HasPrefix(inc, "ssm://") {
-				config, err = loadSSM(config, strings.TrimPrefix(inc, "ssm://"))
+				config, err = loadSSM(config, strings.TrimPrefix(inc, "ssm://"), org)
 				if err != nil {
 					return nil, fmt.Errorf("unable to load ssm param: %v", err)
 				}
 				continue
 			}
-			config, err = loadFiles(config, []string{inc})
+			config, err = loadFiles(config, []string{inc}, org)
 			if err != nil {
 				return nil, fmt.Errorf("unable to load _include files: %v", err)
 			}
@@ -902,6 +1307,34 @@ func loadTags(fn string) (map[string]string, error) {
 	return res, nil
 }
 
+// isTerminal reports whether f is an interactive terminal rather than a pipe or file
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// ask prints the question and returns the line read from stdin without surrounding whitespace
+func ask(question string) string {
+	fmt.Print(question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(answer)
+}
+
+// confirm asks the question and reports whether the answer was yes
+func confirm(question string) bool {
+	switch strings.ToLower(ask(question)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
 const usageTop = `██╗░░░██╗███████╗███████╗████████╗
 ╚██╗░██╔╝██╔════╝██╔════╝╚══██╔══╝
 ░╚████╔╝░█████╗░░█████╗░░░░░██║░░░
@@ -911,15 +1344,30 @@ const usageTop = `██╗░░░██╗███████╗███
 
 
 Usage
-  yeet [-h|-v] [-r <region>] [subcommand]
+  yeet [-h|-v] [-r <region>] [-ssm-fixtures <file>] [subcommand]
 
   -h  display this help
   -v  display the version
-  -r  set the aws region manually
+  -r  set the aws region manually, not needed to run
+      output inputs, output template or validate offline
+  -ssm-fixtures <file>
+      a yaml file of ssm param names to values, used instead of
+      ssm for _include and the ssm template func by output
+      inputs, output template and validate, so they don't need
+      aws credentials. other sub-commands refuse it
 
 Sub-Commands
+  canary    deploy a yeet config as a canary taking some of a stack's requests
   deploy    deploy a yeet stack
+  destroy   delete a yeet stack
+  diff      show what a deploy would change in a yeet stack
+  exec      run a command in a yeet stack's running container with ECS Exec
+  logs      show the logs from a yeet stack's containers
   output    output info about a yeet stack
+  rollback  redeploy a yeet stack on a previous task definition
+  run       run a one-off task from a yeet stack's service
+  status    show the health of a yeet stack's service
+  validate  check a yeet config against the config reference
 
   use <subcommand> -h for subcommand-specific help
 
@@ -927,17 +1375,180 @@ Examples
   TODO
 `
 
-const usageDeploy = `yeet deploy [-tf ./tags.yml] <yeet-config.yml ...>
+const usageDeploy = `yeet deploy [-tf ./tags.yml] [-confirm|-changeset-only|-execute <changeset>] [-skip-validate] <yeet-config.yml ...>
 
 Summary
-  manages the deployment of the Yeet CloudFormation Stack
+  manages the deployment of the Yeet CloudFormation Stack. the config is
+  checked the same way as yeet validate before anything is deployed
 
 Flags
   -tf <file>        a path to a yaml file containing tags
+  -confirm          create a change set, print it and prompt before applying
+                    it. fails without a terminal to prompt on
+  -changeset-only   create a change set, print it and exit without applying
+                    it, so a later step can run -execute
+  -execute <name>   print and apply a change set created by -changeset-only
+  -skip-validate    deploy even if the config has problems
   <yeet-config.yml> a path to one of more yaml files
                     containing the config for the stack
 `
 
-const usageOutput = `yeet output [inputs|running|template]
-TODO
+const usageDestroy = `yeet destroy [-force] <yeet-config.yml ...>
+
+Summary
+  deletes the Yeet CloudFormation Stack after showing its running tasks
+  and asking for the stack name to be typed to confirm. log groups
+  created by the stack are retained and need to be deleted manually
+
+Flags
+  -force            destroy without confirming, for use in CI
+  <yeet-config.yml> a path to one of more yaml files
+                    containing the config for the stack
+`
+
+const usageDiff = `yeet diff [-tf ./tags.yml] <yeet-config.yml ...>
+
+Summary
+  shows the changes a deploy would make to the Yeet CloudFormation Stack
+  using a change set, which is deleted once it has been printed
+
+Flags
+  -tf <file>        a path to a yaml file containing tags
+  <yeet-config.yml> a path to one of more yaml files
+                    containing the config for the stack
+`
+
+const usageRollback = `yeet rollback [-tf ./tags.yml] [-to <revision>|-list] <yeet-config.yml ...>
+
+Summary
+  lists the Task Definition revisions for the Yeet Stack's family and
+  redeploys the stack with the ECS Service pinned to one of them. the
+  Service stays pinned until the next yeet deploy
+
+Flags
+  -tf <file>        a path to a yaml file containing tags
+  -to <revision>    the revision to roll back to, defaults to the one
+                    before the revision the Service is running
+  -list             list the revisions without rolling back
+  <yeet-config.yml> a path to one of more yaml files
+                    containing the config for the stack
+`
+
+const usageStatus = `yeet status [-n 10] <yeet-config.yml ...>
+
+Summary
+  shows the Yeet Stack's ECS Service deployments and their rollout state,
+  the latest service events, the health of every target group the service
+  is registered to, why recently stopped tasks stopped and the running
+  tasks
+
+Flags
+  -n <count>        the number of service events to show, default 10
+  <yeet-config.yml> a path to one of more yaml files
+                    containing the config for the stack
+`
+
+const usageLogs = `yeet logs [-c <container>] [-f] [-since 10m] [-filter <pattern>] <yeet-config.yml ...>
+
+Summary
+  shows the CloudWatch Logs for the Yeet Stack's containers, interleaved
+  by time with each line prefixed by the container and task id
+
+Flags
+  -c <container>    only show logs for this container
+  -f                keep polling for new log events
+  -since <duration> show log events newer than this, default 10m
+  -filter <pattern> a CloudWatch Logs filter pattern to match events with
+  <yeet-config.yml> a path to one of more yaml files
+                    containing the config for the stack
+`
+
+const usageRun = `yeet run [-c <container>] <yeet-config.yml ...> [-- <command ...>]
+
+Summary
+  runs a one-off task, e.g. a database migration, from the task
+  definition the Yeet Stack's service is running, with the service's
+  subnets, security groups and public IP setting. the container's logs
+  are shown until the task stops, and yeet exits with the container's
+  exit code
+
+Flags
+  -c <container>    the container to run the command in, default the only
+                    container, or the one named after the stack
+  <yeet-config.yml> a path to one of more yaml files
+                    containing the config for the stack
+  -- <command ...>  the command to run instead of the container's own
+`
+
+const usageExec = `yeet exec [-task <id>] [-c <container>] <yeet-config.yml ...> [-- <command ...>]
+
+Summary
+  starts an interactive ECS Exec session in a container of one of the
+  Yeet Stack's running tasks. the stack needs to be deployed with
+  aws.ecs.enable_execute_command and the session manager plugin needs
+  to be installed
+
+Flags
+  -task <id>        the task to run the command in, by id or its number in
+                    yeet output running, default the first running task
+  -c <container>    the container to run the command in, default the only
+                    container, or the one named after the stack
+  <yeet-config.yml> a path to one of more yaml files
+                    containing the config for the stack
+  -- <command ...>  the command to run, default /bin/sh
+`
+
+const usageCanary = `yeet canary [promote|abort] [-weight 10] [-tf ./tags.yml] <yeet-config.yml ...>
+
+Summary
+  deploys the config as a canary stack, named after the Yeet Stack with
+  -canary on the end, with its own service and target groups. the Yeet
+  Stack's ALB listener rules, and the listeners of load balancers it
+  created, then forward -weight percent of requests to the canary's
+  target groups. requests with the header X-Yeet-Canary: true always go
+  to the canary. the config can't use blue_green or target_group
+  promote sends every request to the canary, deploys the config to the
+  Yeet Stack, then sends every request back and deletes the canary
+  abort sends every request back to the Yeet Stack and deletes the canary
+  promote and abort need the same config files the canary was deployed
+  with, e.g. yeet canary promote <yeet-config.yml ...>
+  the Yeet Stack can't be deployed or rolled back while its canary exists,
+  as the canary's weights are set outside CloudFormation
+
+Flags
+  -weight <percent> the percent of requests to send to the canary,
+                    default 10
+  -tf <file>        a path to a yaml file containing tags
+  <yeet-config.yml> a path to one of more yaml files
+                    containing the config for the stack
+`
+
+const usageValidate = `yeet validate <yeet-config.yml ...>
+
+Summary
+  checks the merged config against docs/config-reference.yml for unknown
+  keys, values of the wrong type, values which aren't one of the allowed
+  values and missing required keys. every problem is printed along with
+  the config file it came from
+
+Flags
+  <yeet-config.yml> a path to one of more yaml files
+                    containing the config for the stack
+`
+
+const usageOutput = `yeet output [inputs [-explain]|running|template] <yeet-config.yml ...>
+
+Summary
+  inputs prints the config after merging, templating and applying
+  defaults. with -explain it prints every value on its own line along
+  with where it came from: the config file or ssm param and line it's
+  on, the region flag, yeet's built-in defaults, the _defaults block it
+  was merged in from and the <( )> template which produced it
+  running prints the Yeet Stack's running ECS Tasks
+  template prints the rendered CloudFormation template
+
+Flags
+  -explain          show where each input value came from
+  <yeet-config.yml> a path to one of more yaml files
+                    containing the config for the stack
 `
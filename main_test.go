@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      string
+		files     string
+		container string
+	}{
+		{
+			name:      "flags first",
+			args:      "-c web a.yml b.yml -- rake db:migrate",
+			files:     "a.yml b.yml -- rake db:migrate",
+			container: "web",
+		},
+		{
+			name:      "flags after the config files",
+			args:      "a.yml b.yml -c web -- rake db:migrate",
+			files:     "a.yml b.yml -- rake db:migrate",
+			container: "web",
+		},
+		{
+			name:      "flags between the config files",
+			args:      "a.yml -c web b.yml",
+			files:     "a.yml b.yml",
+			container: "web",
+		},
+		{
+			name:  "flags in the command are the command's",
+			args:  "a.yml -- ls -c web",
+			files: "a.yml -- ls -c web",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("run", flag.ContinueOnError)
+			container := fs.String("c", "", "")
+			files := parseArgs(fs, strings.Fields(tt.args))
+			if got := strings.Join(files, " "); got != tt.files {
+				t.Errorf("got files %q, want %q", got, tt.files)
+			}
+			if *container != tt.container {
+				t.Errorf("got container %q, want %q", *container, tt.container)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// origins maps the path of each leaf in the config to where its value came from,
+// a file or ssm param with the line it's on, the region flag or yeet's built-in defaults.
+// Paths are keyed by originKey as config keys, like dns names, can have dots in them.
+type origins map[string]string
+
+func originKey(path []string) string {
+	return strings.Join(path, "\x00")
+}
+
+// record notes source as the origin of every leaf in right which mergeKeys would merge into left,
+// so it has to be called before the merge
+func (o origins) record(left map[string]interface{}, right map[string]interface{}, source string, src []byte) {
+	lines := map[string]int{}
+	if src != nil {
+		var n yamlv3.Node
+		if err := yamlv3.Unmarshal(src, &n); err == nil {
+			yamlLines(&n, nil, lines)
+		}
+	}
+	o.merge(left, right, nil, source, lines)
+}
+
+// merge follows mergeKeys, where the existing left value always wins
+func (o origins) merge(left map[string]interface{}, right map[string]interface{}, prefix []string, source string, lines map[string]int) {
+	for k, rv := range right {
+		path := subPath(prefix, k)
+		lv, ok := left[k]
+		if !ok {
+			o.set(rv, path, source, lines)
+			continue
+		}
+		lm, rm := assertMSI(lv), assertMSI(rv)
+		if lm == nil || rm == nil || k == "_include" {
+			continue
+		}
+		o.merge(lm, rm, path, source, lines)
+	}
+}
+
+// set records source for every leaf under v, lists are leaves as they're never merged
+func (o origins) set(v interface{}, path []string, source string, lines map[string]int) {
+	if m := assertMSI(v); len(m) > 0 {
+		for k, mv := range m {
+			o.set(mv, subPath(path, k), source, lines)
+		}
+		return
+	}
+	if l, ok := lines[originKey(path)]; ok {
+		o[originKey(path)] = fmt.Sprintf("%s:%d", source, l)
+		return
+	}
+	o[originKey(path)] = source
+}
+
+// templated notes the <( )> expression in front of the origin of each value it's found in,
+// as the expression is gone once the config is templated
+func (o origins) templated(v interface{}, path []string) {
+	if m := assertMSI(v); m != nil {
+		for k, mv := range m {
+			o.templated(mv, subPath(path, k))
+		}
+		return
+	}
+	s := fmt.Sprint(v)
+	if !strings.Contains(s, "<(") {
+		return
+	}
+	o[originKey(path)] = fmt.Sprintf("%s <- %s", s, o.of(path))
+}
+
+// of returns where the value at path came from. Values merged in from a _defaults block
+// didn't come from a source of their own, so they're found by looking for the same key in the block.
+func (o origins) of(path []string) string {
+	if s, ok := o[originKey(path)]; ok {
+		return s
+	}
+	for i := len(path) - 2; i >= 0; i-- {
+		if path[i] == "_defaults" {
+			continue
+		}
+		block := append(append([]string{}, path[:i]...), "_defaults")
+		if s := o.of(append(append([]string{}, block...), path[i+1:]...)); s != "" {
+			return fmt.Sprintf("_defaults at %s <- %s", joinPath(block), s)
+		}
+	}
+	// a template which produced a whole map
+	for i := len(path) - 1; i > 0; i-- {
+		if s, ok := o[originKey(path[:i])]; ok {
+			return s
+		}
+	}
+	// a map left empty once its _defaults were removed
+	prefix := originKey(path) + "\x00"
+	keys := []string{}
+	for k := range o {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		return o[keys[0]]
+	}
+	return ""
+}
+
+// printOrigins writes every leaf in the config along with where it came from, sorted by path
+func printOrigins(w io.Writer, values map[string]interface{}, org origins) error {
+	var walk func(v interface{}, path []string) error
+	walk = func(v interface{}, path []string) error {
+		if m := assertMSI(v); len(m) > 0 {
+			for _, k := range sortedKeys(m) {
+				if err := walk(m[k], subPath(path, k)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		s, ok := v.(string)
+		if !ok {
+			bb, err := json.Marshal(jsonValue(v))
+			if err != nil {
+				return fmt.Errorf("failed to marshal %s: %v", joinPath(path), err)
+			}
+			s = string(bb)
+		}
+		fmt.Fprintf(w, "%s: %s  # %s\n", joinPath(path), s, org.of(path))
+		return nil
+	}
+	return walk(values, nil)
+}
+
+// jsonValue converts the maps yaml makes, which have interface{} keys, into ones json can marshal
+func jsonValue(v interface{}) interface{} {
+	if m := assertMSI(v); m != nil {
+		for k, mv := range m {
+			m[k] = jsonValue(mv)
+		}
+		return m
+	}
+	if l, ok := v.([]interface{}); ok {
+		jl := make([]interface{}, len(l))
+		for i, lv := range l {
+			jl[i] = jsonValue(lv)
+		}
+		return jl
+	}
+	return v
+}
+
+// yamlLines finds the line each key in the document is on, keyed by originKey
+func yamlLines(n *yamlv3.Node, path []string, lines map[string]int) {
+	switch n.Kind {
+	case yamlv3.DocumentNode:
+		for _, c := range n.Content {
+			yamlLines(c, path, lines)
+		}
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			p := subPath(path, n.Content[i].Value)
+			lines[originKey(p)] = n.Content[i].Line
+			yamlLines(n.Content[i+1], p, lines)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrtypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+)
+
+// the image manifest media types which can say what platform an image is for
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// downloads image config blobs from ecr, a stalled download would otherwise hold up the deploy forever
+var imageConfigClient = &http.Client{Timeout: 30 * time.Second}
+
+// imageManifest is the part of an image index or manifest which says what platforms it's for
+type imageManifest struct {
+	Manifests []struct {
+		Platform struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+		} `json:"platform"`
+	} `json:"manifests"`
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+}
+
+// imageConfig is the part of a single platform image's config blob which says what platform it's for
+type imageConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+// taskPlatform returns the image architecture and os the task's runtime platform needs
+func taskPlatform(values map[string]interface{}) (string, string) {
+	arch, osName := "amd64", "linux"
+	if v := getValue(values, "aws.ecs.task.architecture"); v != nil && fmt.Sprint(v) == "ARM64" {
+		arch = "arm64"
+	}
+	if v := getValue(values, "aws.ecs.task.os_family"); v != nil && strings.HasPrefix(fmt.Sprint(v), "WINDOWS") {
+		osName = "windows"
+	}
+	return arch, osName
+}
+
+// checkImagePlatforms makes sure the images of containers using ecr are built for the task's runtime platform,
+// as tasks otherwise fail to start with an exec format error long after the deploy started. Images which
+// can't be checked, like ones in registries yeet can't read, are warned about rather than failing the check.
+// Tasks without a runtime platform run images the way they always have, so they aren't checked.
+func checkImagePlatforms(values map[string]interface{}, warn io.Writer) []string {
+	problems := []string{}
+	if getValue(values, "aws.ecs.task.architecture") == nil && getValue(values, "aws.ecs.task.os_family") == nil {
+		return problems
+	}
+	arch, osName := taskPlatform(values)
+	containers := assertMSI(values["containers"])
+	for _, name := range sortedKeys(containers) {
+		ct := assertMSI(containers[name])
+		if _, ok := ct["image"]; ok {
+			continue
+		}
+		e := assertMSI(ct["ecr"])
+		if e == nil {
+			continue
+		}
+		image := fmt.Sprintf("%v:%v", e["repository"], e["tag"])
+		platforms, err := imagePlatforms(e)
+		if err != nil {
+			var nf *imageNotFoundError
+			if errors.As(err, &nf) {
+				problems = append(problems, fmt.Sprintf("containers.%s: %v", name, err))
+				continue
+			}
+			fmt.Fprintf(warn, "cant check the platform of %s for container %s: %v\n", image, name, err)
+			continue
+		}
+		found := false
+		for _, p := range platforms {
+			if p == osName+"/"+arch {
+				found = true
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("containers.%s: image %s is built for %s, not %s/%s", name, image, strings.Join(platforms, ", "), osName, arch))
+		}
+	}
+	return problems
+}
+
+type imageNotFoundError struct {
+	image  string
+	reason string
+}
+
+func (e *imageNotFoundError) Error() string {
+	return fmt.Sprintf("image %s not found: %s", e.image, e.reason)
+}
+
+// imagePlatforms returns the os/arch platforms an ecr image is built for
+func imagePlatforms(e map[string]interface{}) ([]string, error) {
+	repo := fmt.Sprint(e["repository"])
+	tag := fmt.Sprint(e["tag"])
+	client := ecr.NewFromConfig(cfg, func(o *ecr.Options) {
+		if r, ok := e["region"]; ok && fmt.Sprint(r) != "" {
+			o.Region = fmt.Sprint(r)
+		}
+	})
+	var registry *string
+	if a, ok := e["account"]; ok {
+		registry = aws.String(fmt.Sprint(a))
+	}
+
+	out, err := client.BatchGetImage(context.TODO(), &ecr.BatchGetImageInput{
+		RegistryId:         registry,
+		RepositoryName:     aws.String(repo),
+		ImageIds:           []ecrtypes.ImageIdentifier{{ImageTag: aws.String(tag)}},
+		AcceptedMediaTypes: manifestMediaTypes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image: %v", err)
+	}
+	if len(out.Images) < 1 {
+		reason := "no image returned"
+		if len(out.Failures) > 0 {
+			reason = aws.ToString(out.Failures[0].FailureReason)
+		}
+		return nil, &imageNotFoundError{image: repo + ":" + tag, reason: reason}
+	}
+
+	var m imageManifest
+	if err := json.Unmarshal([]byte(aws.ToString(out.Images[0].ImageManifest)), &m); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal image manifest: %v", err)
+	}
+	if len(m.Manifests) > 0 {
+		platforms := []string{}
+		for _, mm := range m.Manifests {
+			platforms = append(platforms, mm.Platform.OS+"/"+mm.Platform.Architecture)
+		}
+		return platforms, nil
+	}
+
+	// a single platform image only says what it's for in its config blob
+	if m.Config.Digest == "" {
+		return nil, fmt.Errorf("image manifest has no platforms or config")
+	}
+	dl, err := client.GetDownloadUrlForLayer(context.TODO(), &ecr.GetDownloadUrlForLayerInput{
+		RegistryId:     registry,
+		RepositoryName: aws.String(repo),
+		LayerDigest:    aws.String(m.Config.Digest),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image config url: %v", err)
+	}
+	resp, err := imageConfigClient.Get(aws.ToString(dl.DownloadUrl))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image config: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get image config: %s", resp.Status)
+	}
+	var ic imageConfig
+	if err := json.NewDecoder(resp.Body).Decode(&ic); err != nil {
+		return nil, fmt.Errorf("failed to decode image config: %v", err)
+	}
+	return []string{ic.OS + "/" + ic.Architecture}, nil
+}
+
+// fargateMemory returns the memory sizes, in MiB, Fargate allows for the cpu units
+func fargateMemory(cpu int) []int {
+	if cpu == 256 {
+		return []int{512, 1024, 2048}
+	}
+	sizes := map[int][3]int{ // min, max and step
+		512:   {1024, 4096, 1024},
+		1024:  {2048, 8192, 1024},
+		2048:  {4096, 16384, 1024},
+		4096:  {8192, 30720, 1024},
+		8192:  {16384, 61440, 4096},
+		16384: {32768, 122880, 8192},
+	}
+	s, ok := sizes[cpu]
+	if !ok {
+		return nil
+	}
+	mem := []int{}
+	for m := s[0]; m <= s[1]; m += s[2] {
+		mem = append(mem, m)
+	}
+	return mem
+}
+
+// parseTaskSize turns cpu or memory like 1024, "1 vCPU" or "2 GB" into cpu units or MiB
+func parseTaskSize(v interface{}, unit string) (int, error) {
+	s := strings.ToLower(strings.TrimSpace(fmt.Sprint(v)))
+	mult := 1.0
+	if strings.HasSuffix(s, unit) {
+		s = strings.TrimSpace(strings.TrimSuffix(s, unit))
+		mult = 1024
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int(f * mult), nil
+}
+
+// checkFargateSize reports task cpu and memory combinations and runtime platforms Fargate won't run
+func checkFargateSize(values map[string]interface{}) []problem {
+	path := []string{"aws", "ecs", "task"}
+	cpuv, memv := getValue(values, "aws.ecs.task.cpu"), getValue(values, "aws.ecs.task.memory")
+	if cpuv == nil || memv == nil {
+		return nil
+	}
+	cpu, err := parseTaskSize(cpuv, "vcpu")
+	if err != nil {
+		return []problem{{subPath(path, "cpu"), fmt.Sprintf("%q isn't cpu units or vCPUs", fmt.Sprint(cpuv))}}
+	}
+	mem, err := parseTaskSize(memv, "gb")
+	if err != nil {
+		return []problem{{subPath(path, "memory"), fmt.Sprintf("%q isn't MiB or GB", fmt.Sprint(memv))}}
+	}
+
+	pp := []problem{}
+	sizes := fargateMemory(cpu)
+	if sizes == nil {
+		pp = append(pp, problem{subPath(path, "cpu"), fmt.Sprintf("Fargate doesn't run tasks with %d cpu units, use 256, 512, 1024, 2048, 4096, 8192 or 16384", cpu)})
+	} else {
+		ok := false
+		for _, m := range sizes {
+			if m == mem {
+				ok = true
+			}
+		}
+		if !ok {
+			allowed := fmt.Sprintf("%d to %d MiB in steps of %d", sizes[0], sizes[len(sizes)-1], sizes[len(sizes)-1]-sizes[len(sizes)-2])
+			if cpu == 256 {
+				allowed = "512, 1024 or 2048 MiB"
+			}
+			pp = append(pp, problem{subPath(path, "memory"), fmt.Sprintf("Fargate doesn't run tasks with %d cpu units and %d MiB, memory has to be %s", cpu, mem, allowed)})
+		}
+	}
+
+	arch, osName := taskPlatform(values)
+	if osName == "windows" {
+		if arch == "arm64" {
+			pp = append(pp, problem{subPath(path, "architecture"), "Fargate only runs Windows tasks on X86_64"})
+		}
+		if cpu < 1024 {
+			pp = append(pp, problem{subPath(path, "cpu"), "Fargate needs at least 1024 cpu units for Windows tasks"})
+		}
+	}
+	return pp
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+func TestGenerateTemplate(t *testing.T) {
+	ssmc, err := loadSSMFixtures("testdata/ssm-fixtures.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ssmc = ssmc
+
+	tests := []struct {
+		config    string   // testdata/render file, rendered over base.yml
+		invalid   bool     // rendered even though yeet validate would refuse it
+		resources []string // logical id: type, each of which must be in the template
+		absent    []string // logical ids which mustn't be
+		contains  []string // snippets which must be in the template
+		excludes  []string // snippets which mustn't be
+	}{
+		{
+			config: "base.yml",
+			resources: []string{
+				"TaskDefinition: AWS::ECS::TaskDefinition",
+				"Service: AWS::ECS::Service",
+				"Role: AWS::IAM::Role",
+			},
+			absent: []string{"TaskSG", "ExecutionRole", "CodeDeployRole"},
+		},
+		{
+			config: "volumes.yml",
+			resources: []string{
+				"TaskSG: AWS::EC2::SecurityGroup",
+				"sgefsEFSIngress: AWS::EC2::SecurityGroupIngress",
+				"sgefsEFSEgress: AWS::EC2::SecurityGroupEgress",
+				"sgotherEFSIngress: AWS::EC2::SecurityGroupIngress",
+				"sgotherEFSEgress: AWS::EC2::SecurityGroupEgress",
+			},
+			contains: []string{
+				"AccessPointId: fsap-1",
+				"IAM: ENABLED",
+				"elasticfilesystem:ClientMount",
+				"ContainerPath: '/data'",
+				"ReadOnly: true",
+			},
+		},
+		{
+			config: "capacity.yml",
+			contains: []string{
+				"- CapacityProvider: FARGATE\n          Base: 1\n          Weight: 1\n",
+				"- CapacityProvider: FARGATE_SPOT\n          Weight: 3\n",
+			},
+			excludes: []string{"LaunchType: FARGATE"},
+		},
+		{
+			config:  "scaling.yml",
+			invalid: true,
+			resources: []string{
+				"AutoScalingTarget: AWS::ApplicationAutoScaling::ScalableTarget",
+				"cpuTargetTrackingPolicy: AWS::ApplicationAutoScaling::ScalingPolicy",
+				"burstScalingAlarm: AWS::CloudWatch::Alarm",
+				"burstScalingPolicy: AWS::ApplicationAutoScaling::ScalingPolicy",
+			},
+			contains: []string{
+				"ScheduledActionName: 'overnight'",
+				"Timezone: 'Australia/Sydney'",
+				"PredefinedMetricType: ECSServiceAverageCPUUtilization",
+				"- ScalingAdjustment: 1\n            MetricIntervalUpperBound: 10\n",
+				"- ScalingAdjustment: 3\n            MetricIntervalLowerBound: 10\n",
+			},
+			// both adjustment and steps are set, the steps win and the adjustment isn't rendered as well
+			excludes: []string{"MetricIntervalLowerBound: 0\n"},
+		},
+		{
+			config: "queue.yml",
+			resources: []string{
+				"QueueScalingPolicy: AWS::ApplicationAutoScaling::ScalingPolicy",
+			},
+			contains: []string{"jobs"},
+		},
+		{
+			config: "schedules.yml",
+			resources: []string{
+				"ScheduleRole: AWS::IAM::Role",
+				"nightlySchedule: AWS::Events::Rule",
+			},
+			contains: []string{"cron(0 3 * * ? *)", "cleanup"},
+		},
+		{
+			config: "alb.yml",
+			resources: []string{
+				"webTargetGroup: AWS::ElasticLoadBalancingV2::TargetGroup",
+				"webALB: AWS::ElasticLoadBalancingV2::LoadBalancer",
+				"webListener: AWS::ElasticLoadBalancingV2::Listener",
+				"webHTTPListener: AWS::ElasticLoadBalancingV2::Listener",
+				"webapiListenerRule: AWS::ElasticLoadBalancingV2::ListenerRule",
+				"webadminListenerRule: AWS::ElasticLoadBalancingV2::ListenerRule",
+				"webmaintenanceListenerRule: AWS::ElasticLoadBalancingV2::ListenerRule",
+				"weboldListenerRule: AWS::ElasticLoadBalancingV2::ListenerRule",
+			},
+			contains: []string{
+				"Field: path-pattern",
+				"Field: http-header",
+				"Field: http-request-method",
+				"Field: host-header",
+				"Type: authenticate-oidc",
+				"Type: fixed-response",
+				"Type: redirect",
+			},
+		},
+		{
+			config: "bluegreen.yml",
+			resources: []string{
+				"webGreenTargetGroup: AWS::ElasticLoadBalancingV2::TargetGroup",
+				"webTestListener: AWS::ElasticLoadBalancingV2::Listener",
+				"CodeDeployRole: AWS::IAM::Role",
+				"CodeDeployApplication: AWS::CodeDeploy::Application",
+				"CodeDeployConfig: AWS::CodeDeploy::DeploymentConfig",
+				"CodeDeployDeploymentGroup: AWS::CodeDeploy::DeploymentGroup",
+			},
+			contains: []string{"Type: CODE_DEPLOY", "Port: 8443"},
+		},
+		{
+			// one of everything CodeDeploy needs, however many ALBs there are
+			config:  "bluegreen-two-albs.yml",
+			invalid: true,
+			resources: []string{
+				"webGreenTargetGroup: AWS::ElasticLoadBalancingV2::TargetGroup",
+				"adminGreenTargetGroup: AWS::ElasticLoadBalancingV2::TargetGroup",
+				"CodeDeployRole: AWS::IAM::Role",
+				"CodeDeployDeploymentGroup: AWS::CodeDeploy::DeploymentGroup",
+			},
+		},
+		{
+			config: "serviceconnect.yml",
+			contains: []string{
+				"ServiceConnectConfiguration:",
+				"Namespace: 'internal'",
+				"DnsName: 'app.internal'",
+				"Name: 'web'",
+			},
+		},
+		{
+			config: "securitygroups.yml",
+			resources: []string{
+				"TaskSG: AWS::EC2::SecurityGroup",
+			},
+			contains: []string{
+				"CidrIp: 10.0.0.0/8",
+				"CidrIpv6: ::/0",
+				"SourceSecurityGroupId: sg-1",
+				"SourcePrefixListId: pl-1",
+				"SourceSecurityGroupId: !ImportValue 'web-TaskSecurityGroup'",
+				"DestinationSecurityGroupId: !ImportValue 'db-TaskSecurityGroup'",
+				"TaskSecurityGroup:",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.config, func(t *testing.T) {
+			files := []string{filepath.Join("testdata/render", tt.config), "testdata/render/base.yml"}
+			if tt.config == "base.yml" {
+				files = files[1:]
+			}
+
+			values, err := readValues(defaults, files, "ap-southeast-2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pp, err := validateValues(values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valid := len(pp) == 0; valid == tt.invalid {
+				t.Errorf("got %d problem(s) validating, want invalid %v: %v", len(pp), tt.invalid, pp)
+			}
+
+			tpl, err := generateTemplate(ecstpl, defaults, files, "ap-southeast-2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(tpl, "<no value>") {
+				t.Errorf("template has an unset value:\n%s", tpl)
+			}
+			// yaml.v3 refuses duplicate keys, which cloudformation would too
+			var cf struct {
+				Resources map[string]struct {
+					Type string `yaml:"Type"`
+				} `yaml:"Resources"`
+			}
+			if err := yamlv3.Unmarshal([]byte(tpl), &cf); err != nil {
+				t.Fatalf("template isn't valid yaml: %v\n%s", err, tpl)
+			}
+			for _, r := range tt.resources {
+				id, typ, _ := strings.Cut(r, ": ")
+				got, ok := cf.Resources[id]
+				if !ok {
+					t.Errorf("no %s resource", id)
+					continue
+				}
+				if got.Type != typ {
+					t.Errorf("got %s of type %s, want %s", id, got.Type, typ)
+				}
+			}
+			for _, id := range tt.absent {
+				if _, ok := cf.Resources[id]; ok {
+					t.Errorf("unexpected %s resource", id)
+				}
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(tpl, s) {
+					t.Errorf("template doesn't have %q", s)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(tpl, s) {
+					t.Errorf("template has %q", s)
+				}
+			}
+		})
+	}
+}
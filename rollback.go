@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/toolsdotgo/sfm/pkg/sfm"
+)
+
+// how many of the most recent revisions rollback lists
+const rollbackListLength = 10
+
+type taskDefRevision struct {
+	arn      string
+	revision int
+	status   types.TaskDefinitionStatus
+}
+
+func (c command) rollbackYeet(args []string, region string, to int, list bool, tagsfile string) int {
+	values, err := readValues(defaults, args, region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read values: %v", err)
+		return 1
+	}
+	stackname, ok := values["name"].(string) // sorry
+	if !ok {
+		fmt.Fprintf(os.Stderr, "no stack name found")
+		return 1
+	}
+
+	h := sfm.Handle{CFNcli: c.cfnc}
+	stack, err := h.Get(stackname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get stack: %v\n", err)
+		return 1
+	}
+
+	client := ecs.NewFromConfig(cfg)
+	current, err := currentTaskDefinition(client, stack)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get current task definition: %v\n", err)
+		return 1
+	}
+	family, currentRev, err := splitTaskDefinition(current)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	revs, err := listRevisions(client, family)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant list task definitions: %v\n", err)
+		return 1
+	}
+
+	var target *taskDefRevision
+	for i, r := range revs {
+		if (to == 0 && r.revision < currentRev) || r.revision == to {
+			target = &revs[i]
+			break
+		}
+	}
+
+	fmt.Printf("Task Definition revisions for %s:\n", family)
+	fmt.Println("   | Revision | Status   | Registered at                       | Images")
+	fmt.Println("---+----------+----------+-------------------------------------+-------------------------------------")
+	loc, _ := time.LoadLocation("Local") // WARN this might break on non-UNIX systems
+	for i, r := range revs {
+		if i >= rollbackListLength {
+			break
+		}
+		def, err := client.DescribeTaskDefinition(context.TODO(), &ecs.DescribeTaskDefinitionInput{
+			TaskDefinition: aws.String(r.arn),
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to describe task definition (%v): %v\n", r.arn, err)
+			return 1
+		}
+		images := []string{}
+		for _, cd := range def.TaskDefinition.ContainerDefinitions {
+			images = append(images, aws.ToString(cd.Image))
+		}
+		mark := ""
+		switch {
+		case r.revision == currentRev:
+			mark = "*"
+		case target != nil && r.revision == target.revision:
+			mark = "->"
+		}
+		registered := def.TaskDefinition.RegisteredAt.In(loc).String()
+		fmt.Printf(" %-2s| %8d | %-8s | %-35.35s | %s\n", mark, r.revision, r.status, registered, strings.Join(images, ", "))
+	}
+	fmt.Println()
+
+	if list {
+		return 0
+	}
+	if target == nil {
+		if to == 0 {
+			fmt.Fprintf(os.Stderr, "no revision before %d to roll back to\n", currentRev)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "revision %d not found for %s\n", to, family)
+		return 1
+	}
+	if target.revision == currentRev {
+		fmt.Fprintf(os.Stderr, "service is already running revision %d\n", currentRev)
+		return 1
+	}
+
+	arn := target.arn
+	if target.status != types.TaskDefinitionStatusActive {
+		// cloudformation deregisters the revisions it replaces, and services can't be moved on to an inactive one
+		arn, err = reregisterTaskDefinition(client, target.arn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cant register inactive revision %d again: %v\n", target.revision, err)
+			return 1
+		}
+		fmt.Printf("Revision %d is %s, registered it again as %s\n", target.revision, target.status, arn)
+	}
+
+	fmt.Printf("Rolling back %s from revision %d to %s\n", stackname, currentRev, arn)
+	fmt.Println("The service stays pinned to it until the next deploy")
+	fmt.Println()
+	setValue(values, "aws.ecs.task_definition", arn)
+	return c.deployValues(values, args, deployFlags{tagsfile: tagsfile})
+}
+
+// currentTaskDefinition returns the arn of the task definition the stack's service is running, which is
+// its primary task set's for blue/green services
+func currentTaskDefinition(client *ecs.Client, s sfm.Stack) (string, error) {
+	svc, err := stackService(client, s)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(primaryTaskSet(svc).TaskDefinition), nil
+}
+
+// splitTaskDefinition returns the family and revision from a task definition arn
+func splitTaskDefinition(arn string) (string, int, error) {
+	p := strings.LastIndex(arn, ":")
+	if p < 0 {
+		return "", 0, fmt.Errorf("cant find revision in task definition %s", arn)
+	}
+	rev, err := strconv.Atoi(arn[p+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("cant parse revision in task definition %s: %v", arn, err)
+	}
+	family := arn[:p]
+	family = family[strings.LastIndex(family, "/")+1:]
+	return family, rev, nil
+}
+
+// listRevisions returns the active and inactive revisions of the family, newest first
+func listRevisions(client *ecs.Client, family string) ([]taskDefRevision, error) {
+	revs := []taskDefRevision{}
+	for _, status := range []types.TaskDefinitionStatus{types.TaskDefinitionStatusActive, types.TaskDefinitionStatusInactive} {
+		pg := ecs.NewListTaskDefinitionsPaginator(client, &ecs.ListTaskDefinitionsInput{
+			FamilyPrefix: aws.String(family),
+			Status:       status,
+		})
+		for pg.HasMorePages() {
+			out, err := pg.NextPage(context.TODO())
+			if err != nil {
+				return nil, err
+			}
+			for _, arn := range out.TaskDefinitionArns {
+				f, rev, err := splitTaskDefinition(arn)
+				if err != nil {
+					return nil, err
+				}
+				// the prefix also matches other families which start with this one
+				if f != family {
+					continue
+				}
+				revs = append(revs, taskDefRevision{arn: arn, revision: rev, status: status})
+			}
+		}
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].revision > revs[j].revision })
+	return revs, nil
+}
+
+// reregisterTaskDefinition registers a copy of the task definition and returns the new revision's arn
+func reregisterTaskDefinition(client *ecs.Client, arn string) (string, error) {
+	out, err := client.DescribeTaskDefinition(context.TODO(), &ecs.DescribeTaskDefinitionInput{
+		Include:        []types.TaskDefinitionField{"TAGS"},
+		TaskDefinition: aws.String(arn),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe task definition: %v", err)
+	}
+	td := out.TaskDefinition
+	in := &ecs.RegisterTaskDefinitionInput{
+		ContainerDefinitions:    td.ContainerDefinitions,
+		Family:                  td.Family,
+		Cpu:                     td.Cpu,
+		EphemeralStorage:        td.EphemeralStorage,
+		ExecutionRoleArn:        td.ExecutionRoleArn,
+		InferenceAccelerators:   td.InferenceAccelerators,
+		IpcMode:                 td.IpcMode,
+		Memory:                  td.Memory,
+		NetworkMode:             td.NetworkMode,
+		PidMode:                 td.PidMode,
+		PlacementConstraints:    td.PlacementConstraints,
+		ProxyConfiguration:      td.ProxyConfiguration,
+		RequiresCompatibilities: td.RequiresCompatibilities,
+		RuntimePlatform:         td.RuntimePlatform,
+		TaskRoleArn:             td.TaskRoleArn,
+		Volumes:                 td.Volumes,
+	}
+	if len(out.Tags) > 0 {
+		in.Tags = out.Tags
+	}
+	reg, err := client.RegisterTaskDefinition(context.TODO(), in)
+	if err != nil {
+		return "", fmt.Errorf("failed to register task definition: %v", err)
+	}
+	return aws.ToString(reg.TaskDefinition.TaskDefinitionArn), nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/toolsdotgo/sfm/pkg/sfm"
+)
+
+// how long run keeps reading logs after the task stops, as CloudWatch Logs lags behind the container
+const runLogsGrace = 5 * time.Second
+
+// runYeet runs a one-off task from the service's current task definition and network configuration,
+// optionally with a different command for one container, and returns that container's exit code
+func (c command) runYeet(args []string, region string, container string) int {
+	files, cmd := splitCommand(args)
+	values, err := readValues(defaults, files, region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read values: %v", err)
+		return 1
+	}
+	stackname, ok := values["name"].(string) // sorry
+	if !ok {
+		fmt.Fprintf(os.Stderr, "no stack name found")
+		return 1
+	}
+	container, err = runContainer(values, container)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant pick container: %v\n", err)
+		return 1
+	}
+
+	h := sfm.Handle{CFNcli: c.cfnc}
+	stack, err := h.Get(stackname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get stack: %v\n", err)
+		return 1
+	}
+	sources, err := logSources(values, stack, container)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant find log groups: %v\n", err)
+		return 1
+	}
+
+	client := ecs.NewFromConfig(cfg)
+	svc, err := stackService(client, stack)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get service: %v\n", err)
+		return 1
+	}
+
+	// blue/green services run their task definition in a task set
+	ts := primaryTaskSet(svc)
+	in := &ecs.RunTaskInput{
+		Cluster:                  svc.ClusterArn,
+		TaskDefinition:           ts.TaskDefinition,
+		NetworkConfiguration:     ts.NetworkConfiguration,
+		CapacityProviderStrategy: ts.CapacityProviderStrategy,
+		LaunchType:               svc.LaunchType,
+		PlatformVersion:          ts.PlatformVersion,
+		PropagateTags:            types.PropagateTagsTaskDefinition,
+		StartedBy:                aws.String("yeet-run"),
+	}
+	if len(cmd) > 0 {
+		in.Overrides = &types.TaskOverride{
+			ContainerOverrides: []types.ContainerOverride{{Name: aws.String(container), Command: cmd}},
+		}
+	}
+	start := time.Now()
+	out, err := client.RunTask(context.TODO(), in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant run task: %v\n", err)
+		return 1
+	}
+	if len(out.Failures) > 0 {
+		f := out.Failures[0]
+		fmt.Fprintf(os.Stderr, "cant run task: %s %s\n", aws.ToString(f.Reason), aws.ToString(f.Detail))
+		return 1
+	}
+	task := out.Tasks[0]
+	id := lastSegment(aws.ToString(task.TaskArn), "/")
+	fmt.Fprintf(os.Stderr, "Started task %s from %s\n", id, lastSegment(aws.ToString(ts.TaskDefinition), "/"))
+
+	for i := range sources {
+		sources[i].task = id
+	}
+	var stoppedAt time.Time
+	var waitErr error
+	stop := func() bool {
+		if !stoppedAt.IsZero() {
+			return time.Since(stoppedAt) > runLogsGrace
+		}
+		t, err := describeTask(client, task)
+		if err != nil {
+			waitErr = err
+			return true
+		}
+		if t.LastStatus != nil && *t.LastStatus == "STOPPED" {
+			task = t
+			stoppedAt = time.Now()
+		}
+		return false
+	}
+	if err := tailLogs(logsClients(), sources, start, "", stop, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "cant get logs: %v\n", err)
+	}
+	if waitErr != nil {
+		fmt.Fprintf(os.Stderr, "cant wait for task: %v\n", waitErr)
+		return 1
+	}
+
+	for _, ct := range task.Containers {
+		if aws.ToString(ct.Name) != container {
+			continue
+		}
+		if ct.ExitCode == nil {
+			fmt.Fprintf(os.Stderr, "Task %s stopped without container %s exiting: %s %s\n", id, container, aws.ToString(task.StoppedReason), aws.ToString(ct.Reason))
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Task %s stopped, container %s exited %d\n", id, container, *ct.ExitCode)
+		return int(*ct.ExitCode)
+	}
+	fmt.Fprintf(os.Stderr, "Task %s stopped without container %s: %s\n", id, container, aws.ToString(task.StoppedReason))
+	return 1
+}
+
+// describeTask returns the latest state of a task
+func describeTask(client *ecs.Client, task types.Task) (types.Task, error) {
+	out, err := client.DescribeTasks(context.TODO(), &ecs.DescribeTasksInput{
+		Cluster: task.ClusterArn,
+		Tasks:   []string{aws.ToString(task.TaskArn)},
+	})
+	if err != nil {
+		return task, fmt.Errorf("failed to describe task: %v", err)
+	}
+	if len(out.Tasks) != 1 {
+		return task, fmt.Errorf("only a single task should be returned, %v found", len(out.Tasks))
+	}
+	return out.Tasks[0], nil
+}
+
+// splitCommand splits args at the first --, into config files and the command to run
+func splitCommand(args []string) ([]string, []string) {
+	for i, a := range args {
+		if a == "--" {
+			return args[:i], args[i+1:]
+		}
+	}
+	return args, nil
+}
+
+// runContainer returns the container to run the command in, the one named or the only one in the config,
+// falling back to the one named after the stack when there's more than one
+func runContainer(values map[string]interface{}, container string) (string, error) {
+	containers := assertMSI(values["containers"])
+	if container != "" {
+		if _, ok := containers[container]; !ok {
+			return "", fmt.Errorf("container %s not in config", container)
+		}
+		return container, nil
+	}
+	names := sortedKeys(containers)
+	if len(names) == 1 {
+		return names[0], nil
+	}
+	if _, ok := containers[fmt.Sprint(values["name"])]; ok {
+		return fmt.Sprint(values["name"]), nil
+	}
+	return "", fmt.Errorf("config has %d containers, use -c to pick one", len(names))
+}
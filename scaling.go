@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the metric each scaling.target_tracking metric is, custom ones use when instead
+var trackedMetrics = map[string]string{
+	"cpu":      "ECSServiceAverageCPUUtilization",
+	"memory":   "ECSServiceAverageMemoryUtilization",
+	"requests": "ALBRequestCountPerTarget",
+}
+
+// albRule returns the listener rule whose load balancer an ALB's requests are counted on,
+// the one named or the first when there's no name. It's empty when they're counted on
+// the load balancer yeet creates for the ALB.
+func albRule(albs interface{}, lb interface{}, rule interface{}) (string, error) {
+	alb := assertMSI(assertMSI(albs)[fmt.Sprint(lb)])
+	if alb == nil {
+		return "", fmt.Errorf("no application load balancer %v to count requests on", lb)
+	}
+	rules := assertMSI(alb["listener_rules"])
+	if rule != nil {
+		if _, ok := rules[fmt.Sprint(rule)]; !ok {
+			return "", fmt.Errorf("application load balancer %v has no listener rule %v", lb, rule)
+		}
+		return fmt.Sprint(rule), nil
+	}
+	if alb["load_balancer"] != nil {
+		return "", nil
+	}
+	keys := sortedKeys(rules)
+	if len(keys) < 1 {
+		return "", fmt.Errorf("application load balancer %v has no listener rules to count requests on", lb)
+	}
+	return keys[0], nil
+}
+
+// albLabel returns the load balancer part of the label ALB requests are counted by, ready to go in a !Sub,
+// from the listener of the rule or the load balancer yeet creates when the rule doesn't have one
+func albLabel(albs interface{}, lb interface{}, rule string) string {
+	alb := assertMSI(assertMSI(albs)[fmt.Sprint(lb)])
+	r := assertMSI(assertMSI(alb["listener_rules"])[rule])
+	if arn, ok := r["listener_arn"]; ok {
+		return lbFullName(arn)
+	}
+	return fmt.Sprintf("${%s.LoadBalancerFullName}", logicalID(fmt.Sprint(lb), "ALB"))
+}
+
+// lbFullName turns a listener arn into the app/<name>/<id> of its load balancer,
+// which is what CloudWatch and target tracking know load balancers by
+func lbFullName(arn interface{}) string {
+	_, s, ok := strings.Cut(fmt.Sprint(arn), ":listener/")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) < 3 {
+		return ""
+	}
+	return strings.Join(parts[:3], "/")
+}
+
+// queueARN returns the arn of a queue from scaling.queue.name ready to go in the template,
+// a name is taken to be a queue in the stack's region and account, which an arn has to be in too
+func queueARN(input interface{}) string {
+	s := fmt.Sprint(input)
+	if strings.HasPrefix(s, "arn:") {
+		return quoteYAML(s)
+	}
+	return fmt.Sprintf("!Sub 'arn:${AWS::Partition}:sqs:${AWS::Region}:${AWS::AccountId}:%s'", s)
+}
+
+// checkScaling reports a minimum above the maximum, target tracking policies which are missing
+// what their metric needs, a queue in another region and step scaling policies with both a single
+// adjustment and steps
+func checkScaling(values map[string]interface{}) []problem {
+	pp := []problem{}
+	min, minErr := strconv.Atoi(fmt.Sprint(getValue(values, "scaling.min")))
+	max, maxErr := strconv.Atoi(fmt.Sprint(getValue(values, "scaling.max")))
+	if minErr == nil && maxErr == nil && min > max {
+		pp = append(pp, problem{[]string{"scaling", "min"}, fmt.Sprintf("%d is above scaling.max of %d", min, max)})
+	}
+
+	path := []string{"scaling", "target_tracking"}
+	tts := assertMSI(getValue(values, "scaling.target_tracking"))
+	albs := getValue(values, "aws.application_load_balancers")
+	for _, name := range sortedKeys(tts) {
+		tt := assertMSI(tts[name])
+		metric := fmt.Sprint(tt["metric"])
+		switch metric {
+		case "requests":
+			if tt["load_balancer"] == nil {
+				pp = append(pp, problem{subPath(subPath(path, name), "load_balancer"), "required when metric is requests"})
+				continue
+			}
+			if _, err := albRule(albs, tt["load_balancer"], tt["listener_rule"]); err != nil {
+				pp = append(pp, problem{subPath(subPath(path, name), "load_balancer"), err.Error()})
+				continue
+			}
+			if t := getValue(assertMSI(albs), fmt.Sprint(tt["load_balancer"])+".target_group"); t != nil {
+				pp = append(pp, problem{subPath(subPath(path, name), "load_balancer"), "requests can only be counted on target groups yeet creates, not target_group " + fmt.Sprint(t)})
+			}
+		case "custom":
+			if getValue(tt, "when.metric") == nil {
+				pp = append(pp, problem{append(subPath(path, name), "when", "metric"), "required when metric is custom"})
+			}
+		}
+	}
+
+	// the backlog metric math only sees queues in the stack's region
+	if q := getValue(values, "scaling.queue.name"); q != nil && strings.HasPrefix(fmt.Sprint(q), "arn:") {
+		parts := strings.Split(fmt.Sprint(q), ":")
+		if region := getValue(values, "aws.region"); len(parts) > 3 && region != nil && parts[3] != fmt.Sprint(region) {
+			pp = append(pp, problem{[]string{"scaling", "queue", "name"}, fmt.Sprintf("the queue has to be in the stack's region %v to scale on its metrics", region)})
+		}
+	}
+
+	path = []string{"scaling", "step_scaling"}
+	ss := assertMSI(getValue(values, "scaling.step_scaling"))
+	for _, name := range sortedKeys(ss) {
+		s := assertMSI(ss[name])
+		_, adj := s["adjustment"]
+		_, steps := s["steps"]
+		if adj && steps {
+			pp = append(pp, problem{subPath(path, name), "set either adjustment or steps, not both"})
+		}
+	}
+	return pp
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// containerOverride is how RunTask takes changes to a container, which EventBridge passes on as the target's input
+type containerOverride struct {
+	Name        string           `json:"name"`
+	Command     []string         `json:"command,omitempty"`
+	Environment []overrideEnvVar `json:"environment,omitempty"`
+}
+
+type overrideEnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// taskOverrides turns a schedule's overrides into the json input of its EventBridge target, quoted for the template
+func taskOverrides(input interface{}) (string, error) {
+	overrides := assertMSI(input)
+	co := []containerOverride{}
+	for _, name := range sortedKeys(overrides) {
+		o := containerOverride{Name: name}
+		ov := assertMSI(overrides[name])
+		if cmd, ok := ov["command"].([]interface{}); ok {
+			for _, c := range cmd {
+				o.Command = append(o.Command, fmt.Sprint(c))
+			}
+		}
+		env := assertMSI(ov["environment"])
+		for _, k := range sortedKeys(env) {
+			o.Environment = append(o.Environment, overrideEnvVar{Name: k, Value: fmt.Sprint(env[k])})
+		}
+		co = append(co, o)
+	}
+	bs, err := json.Marshal(map[string]interface{}{"containerOverrides": co})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal overrides: %v", err)
+	}
+	return quoteYAML(string(bs)), nil
+}
+
+// checkSchedules reports schedules overriding containers the task doesn't have
+func checkSchedules(values map[string]interface{}) []problem {
+	pp := []problem{}
+	containers := assertMSI(values["containers"])
+	schedules := assertMSI(values["schedules"])
+	for _, name := range sortedKeys(schedules) {
+		overrides := assertMSI(assertMSI(schedules[name])["overrides"])
+		for _, c := range sortedKeys(overrides) {
+			if _, ok := containers[c]; !ok {
+				pp = append(pp, problem{[]string{"schedules", name, "overrides", c}, "no container " + c + " to override"})
+			}
+		}
+	}
+	return pp
+}
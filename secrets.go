@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// secretRef returns the ValueFrom for a container secret and the resource the execution role needs
+// to be allowed to read it, both ready to go in the template. A secret is an ssm param name or arn,
+// a secrets manager arn, or a map of a secrets manager arn with the json key and version to use.
+func secretRef(input interface{}) (string, string) {
+	if m := assertMSI(input); m != nil {
+		arn := secretValue(m["secret"])
+		opts := []string{secretValue(m["key"]), secretValue(m["version_stage"]), secretValue(m["version_id"])}
+		if strings.Join(opts, "") == "" {
+			return quoteYAML(arn), quoteYAML(secretARN(arn))
+		}
+		return quoteYAML(strings.Join(append([]string{arn}, opts...), ":")), quoteYAML(secretARN(arn))
+	}
+
+	s := secretValue(input)
+	if strings.HasPrefix(s, "arn:") {
+		return quoteYAML(s), quoteYAML(secretARN(s))
+	}
+	// a param name, which only works in the stack's region and account
+	sub := fmt.Sprintf("!Sub 'arn:${AWS::Partition}:ssm:${AWS::Region}:${AWS::AccountId}:parameter/%s'", strings.TrimPrefix(s, "/"))
+	return sub, sub
+}
+
+// secretARN strips the json key and version from a secrets manager arn, which IAM doesn't know about
+func secretARN(arn string) string {
+	parts := strings.Split(arn, ":")
+	if len(parts) > 7 && parts[2] == "secretsmanager" {
+		return strings.Join(parts[:7], ":")
+	}
+	return arn
+}
+
+func secretValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
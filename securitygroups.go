@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// allow_ingress_from and allow_egress_to entries with this prefix are the task security group of another yeet stack
+const stackPeerPrefix = "stack:"
+
+// the export stacks with a task security group share it with other yeet stacks by, after the stack name
+const taskSGExportSuffix = "-TaskSecurityGroup"
+
+// sgPeer returns the property of a task security group rule for an allow_ingress_from or allow_egress_to entry,
+// which is a CIDR, security group id, prefix list id or stack:<name>. direction is Source or Destination.
+func sgPeer(input interface{}, direction string) string {
+	s := fmt.Sprint(input)
+	switch {
+	case strings.HasPrefix(s, "sg-"):
+		return fmt.Sprintf("%sSecurityGroupId: %s", direction, s)
+	case strings.HasPrefix(s, "pl-"):
+		return fmt.Sprintf("%sPrefixListId: %s", direction, s)
+	case strings.HasPrefix(s, stackPeerPrefix):
+		return fmt.Sprintf("%sSecurityGroupId: !ImportValue '%s%s'", direction, strings.TrimPrefix(s, stackPeerPrefix), taskSGExportSuffix)
+	case strings.Contains(s, ":"):
+		return "CidrIpv6: " + s
+	}
+	return "CidrIp: " + s
+}
+
+// checkSecurityGroups reports task ingress and egress rules for something other than a CIDR, security group,
+// prefix list or stack:<name>, and for stack:<name> without a name or with the stack's own name
+func checkSecurityGroups(values map[string]interface{}) []problem {
+	pp := []problem{}
+	for _, rules := range []struct{ key, peers string }{{"ingress", "allow_ingress_from"}, {"egress", "allow_egress_to"}} {
+		rr := assertMSI(getValue(values, "aws.ecs.task."+rules.key))
+		for _, name := range sortedKeys(rr) {
+			for i, peer := range asList(assertMSI(rr[name])[rules.peers]) {
+				s := fmt.Sprint(peer)
+				path := []string{"aws", "ecs", "task", rules.key, name, rules.peers, fmt.Sprintf("[%d]", i)}
+				if !strings.HasPrefix(s, stackPeerPrefix) {
+					if _, _, err := net.ParseCIDR(s); err != nil && !strings.HasPrefix(s, "sg-") && !strings.HasPrefix(s, "pl-") {
+						pp = append(pp, problem{path, fmt.Sprintf("%q is not a CIDR, security group id, prefix list id or stack:<name>", s)})
+					}
+					continue
+				}
+				stack := strings.TrimPrefix(s, stackPeerPrefix)
+				if stack == "" {
+					pp = append(pp, problem{path, "needs the name of the yeet stack, like stack:my-service"})
+				}
+				if stack == fmt.Sprint(values["name"]) {
+					pp = append(pp, problem{path, "a stack can't import its own task security group"})
+				}
+			}
+		}
+	}
+	return pp
+}
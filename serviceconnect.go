@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Service Connect port names are lowercase letters, numbers, underscores and hyphens, not starting with a hyphen
+var serviceConnectPortName = regexp.MustCompile("^[a-z0-9_][a-z0-9_-]{0,63}$")
+
+// serviceConnectPort returns the name of the aws.service_connect.services entry for a container's port,
+// which its port mapping is named, or empty when the port isn't one
+func serviceConnectPort(sc interface{}, container string, port interface{}) string {
+	services := assertMSI(assertMSI(sc)["services"])
+	for _, k := range sortedKeys(services) {
+		s := assertMSI(services[k])
+		if fmt.Sprint(s["container"]) == container && fmt.Sprint(s["port"]) == fmt.Sprint(port) {
+			return k
+		}
+	}
+	return ""
+}
+
+// checkServiceConnect reports Service Connect services which aren't a port of one of the containers
+// or whose name Service Connect won't take, and Service Connect on a blue/green service
+func checkServiceConnect(values map[string]interface{}) []problem {
+	pp := []problem{}
+	if getValue(values, "aws.service_connect") == nil {
+		return pp
+	}
+	if fmt.Sprint(getValue(values, "aws.ecs.deployment.strategy")) == "blue_green" {
+		pp = append(pp, problem{[]string{"aws", "service_connect"}, "ECS can't use Service Connect with blue_green deployments"})
+	}
+
+	containers := assertMSI(values["containers"])
+	services := assertMSI(getValue(values, "aws.service_connect.services"))
+	for _, name := range sortedKeys(services) {
+		path := []string{"aws", "service_connect", "services", name}
+		if !serviceConnectPortName.MatchString(name) {
+			pp = append(pp, problem{path, "names can only be up to 64 lowercase letters, numbers, underscores and hyphens, not starting with a hyphen"})
+		}
+		s := assertMSI(services[name])
+		if s["container"] == nil || s["port"] == nil {
+			// they're required, which is reported already
+			continue
+		}
+		c, ok := containers[fmt.Sprint(s["container"])]
+		if !ok {
+			pp = append(pp, problem{subPath(path, "container"), fmt.Sprintf("there's no container %v", s["container"])})
+			continue
+		}
+		found := false
+		for _, p := range asList(assertMSI(c)["ports"]) {
+			for _, port := range assertMSI(p) {
+				if fmt.Sprint(port) == fmt.Sprint(s["port"]) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			pp = append(pp, problem{subPath(path, "port"), fmt.Sprintf("container %v has no port %v", s["container"], s["port"])})
+		}
+	}
+	return pp
+}
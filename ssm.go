@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"gopkg.in/yaml.v2"
+)
+
+// ssmAPI is the part of the SSM client yeet uses, so params can come from somewhere other than SSM
+type ssmAPI interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// ssmFixtures stands in for SSM with param values from a yaml file, keyed by param name.
+// Values which aren't strings are turned back into yaml, for params used with _include.
+type ssmFixtures struct {
+	file   string
+	params map[string]string
+}
+
+func loadSSMFixtures(fn string) (ssmFixtures, error) {
+	f := ssmFixtures{file: fn, params: map[string]string{}}
+	bs, err := os.ReadFile(filepath.Clean(fn))
+	if err != nil {
+		return f, fmt.Errorf("failed to read file: %v", err)
+	}
+	var pm map[string]interface{}
+	if err := yaml.Unmarshal(bs, &pm); err != nil {
+		return f, fmt.Errorf("failed to unmarshal yaml: %v", err)
+	}
+	for k, v := range pm {
+		if s, ok := v.(string); ok {
+			f.params[k] = s
+			continue
+		}
+		bs, err := yaml.Marshal(v)
+		if err != nil {
+			return f, fmt.Errorf("failed to marshal %s: %v", k, err)
+		}
+		f.params[k] = string(bs)
+	}
+	return f, nil
+}
+
+func (f ssmFixtures) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	name := aws.ToString(params.Name)
+	v, ok := f.params[name]
+	if !ok {
+		return nil, fmt.Errorf("param %s isn't in the ssm fixtures file %s", name, f.file)
+	}
+	return &ssm.GetParameterOutput{
+		Parameter: &types.Parameter{Name: params.Name, Value: aws.String(v)},
+	}, nil
+}
+
+// noSSM is used when there's no region, so rendering offline only fails if the config needs a param
+type noSSM struct{}
+
+func (noSSM) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	return nil, fmt.Errorf("no region set to get param %s from ssm - set one as flag or env var, or use -ssm-fixtures", aws.ToString(params.Name))
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	elb "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/toolsdotgo/sfm/pkg/sfm"
+)
+
+// how many recently stopped tasks status shows
+const statusStoppedTasks = 10
+
+func (c command) statusYeet(args []string, region string, events int) int {
+	values, err := readValues(defaults, args, region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read values: %v", err)
+		return 1
+	}
+	stackname, ok := values["name"].(string) // sorry
+	if !ok {
+		fmt.Fprintf(os.Stderr, "no stack name found")
+		return 1
+	}
+
+	h := sfm.Handle{CFNcli: c.cfnc}
+	stack, err := h.Get(stackname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get stack: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Stack %s: %s\n", stack.Name, stack.Status)
+	if stack.Reason != "" {
+		fmt.Printf("  %s\n", stack.Reason)
+	}
+	fmt.Println()
+
+	client := ecs.NewFromConfig(cfg)
+	svc, err := stackService(client, stack)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant get service: %v\n", err)
+		return 1
+	}
+	loc, _ := time.LoadLocation("Local") // WARN this might break on non-UNIX systems
+
+	fmt.Printf("Service %s: %s, %d desired, %d running, %d pending\n",
+		aws.ToString(svc.ServiceName), aws.ToString(svc.Status), svc.DesiredCount, svc.RunningCount, svc.PendingCount)
+	fmt.Println()
+
+	fmt.Println("Deployments:")
+	fmt.Println(" Status  | Rollout     | Task Version                        | Desired | Running | Pending | Failed | Updated at")
+	fmt.Println("---------+-------------+-------------------------------------+---------+---------+---------+--------+-----------------------------------")
+	for _, d := range svc.Deployments {
+		vers := lastSegment(aws.ToString(d.TaskDefinition), "/")
+		fmt.Printf(" %-7s | %-11s | %-35s | %7d | %7d | %7d | %6d | %s\n",
+			aws.ToString(d.Status), d.RolloutState, vers, d.DesiredCount, d.RunningCount, d.PendingCount, d.FailedTasks, aws.ToTime(d.UpdatedAt).In(loc))
+		if d.RolloutStateReason != nil {
+			fmt.Printf("         | %s\n", *d.RolloutStateReason)
+		}
+	}
+	fmt.Println()
+
+	fmt.Printf("Latest Service Events:\n")
+	for i, e := range svc.Events {
+		if i >= events {
+			break
+		}
+		fmt.Printf(" %s  %s\n", aws.ToTime(e.CreatedAt).In(loc).Format("2006-01-02 15:04:05 MST"), aws.ToString(e.Message))
+	}
+	fmt.Println()
+
+	if len(svc.LoadBalancers) > 0 {
+		elbc := elb.NewFromConfig(cfg)
+		fmt.Println("Target Health:")
+		for _, lb := range svc.LoadBalancers {
+			if lb.TargetGroupArn == nil {
+				continue
+			}
+			th, err := elbc.DescribeTargetHealth(context.TODO(), &elb.DescribeTargetHealthInput{
+				TargetGroupArn: lb.TargetGroupArn,
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "cant describe target health for %s: %v\n", *lb.TargetGroupArn, err)
+				continue
+			}
+			fmt.Printf(" %s\n", lastSegment(*lb.TargetGroupArn, ":"))
+			if len(th.TargetHealthDescriptions) < 1 {
+				fmt.Println("   no registered targets")
+			}
+			for _, t := range th.TargetHealthDescriptions {
+				target := fmt.Sprintf("%s:%d", aws.ToString(t.Target.Id), aws.ToInt32(t.Target.Port))
+				state, reason := "", ""
+				if t.TargetHealth != nil {
+					state = string(t.TargetHealth.State)
+					if t.TargetHealth.Description != nil {
+						reason = fmt.Sprintf("%s: %s", t.TargetHealth.Reason, *t.TargetHealth.Description)
+					}
+				}
+				fmt.Printf("   %-21s %-10s %s\n", target, state, reason)
+			}
+		}
+		fmt.Println()
+	}
+
+	if err := printStoppedTasks(client, svc); err != nil {
+		fmt.Fprintf(os.Stderr, "cant describe stopped tasks: %v\n", err)
+	}
+	fmt.Println()
+
+	_, err = describeService(stack)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant describe service: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// printStoppedTasks prints why the service's most recently stopped tasks stopped
+func printStoppedTasks(client *ecs.Client, svc types.Service) error {
+	list, err := client.ListTasks(context.TODO(), &ecs.ListTasksInput{
+		Cluster:       svc.ClusterArn,
+		ServiceName:   svc.ServiceName,
+		DesiredStatus: types.DesiredStatusStopped,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get stopped task ARNs: %v", err)
+	}
+	fmt.Println("Recently Stopped Tasks:")
+	if len(list.TaskArns) < 1 {
+		fmt.Println(" none")
+		return nil
+	}
+	tasks, err := client.DescribeTasks(context.TODO(), &ecs.DescribeTasksInput{
+		Cluster: svc.ClusterArn,
+		Tasks:   list.TaskArns,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe stopped tasks: %v", err)
+	}
+	sort.Slice(tasks.Tasks, func(i, j int) bool {
+		return aws.ToTime(tasks.Tasks[i].StoppedAt).After(aws.ToTime(tasks.Tasks[j].StoppedAt))
+	})
+	loc, _ := time.LoadLocation("Local") // WARN this might break on non-UNIX systems
+	for i, t := range tasks.Tasks {
+		if i >= statusStoppedTasks {
+			break
+		}
+		id := lastSegment(aws.ToString(t.TaskArn), "/")
+		stopped := "STOPPING"
+		if t.StoppedAt != nil {
+			stopped = t.StoppedAt.In(loc).Format("2006-01-02 15:04:05 MST")
+		}
+		fmt.Printf(" %s  %s  %s: %s\n", stopped, id, t.StopCode, aws.ToString(t.StoppedReason))
+		for _, ct := range t.Containers {
+			exit := "-"
+			if ct.ExitCode != nil {
+				exit = fmt.Sprint(*ct.ExitCode)
+			}
+			fmt.Printf("   %-33s exit %-4s %s\n", aws.ToString(ct.Name), exit, aws.ToString(ct.Reason))
+		}
+	}
+	return nil
+}
+
+// stackService returns the ECS Service created by the stack
+func stackService(client *ecs.Client, s sfm.Stack) (types.Service, error) {
+	serviceArn := s.Outputs["Service"]
+	if serviceArn == "" {
+		return types.Service{}, fmt.Errorf("no service in stack outputs")
+	}
+	clusterArn := s.Outputs["Cluster"]
+	if clusterArn == "" {
+		return types.Service{}, fmt.Errorf("no cluster in stack outputs")
+	}
+	service, err := client.DescribeServices(context.TODO(), &ecs.DescribeServicesInput{
+		Cluster:  aws.String(clusterArn),
+		Services: []string{serviceArn},
+	})
+	if err != nil {
+		return types.Service{}, fmt.Errorf("failed to get service: %v", err)
+	}
+	if len(service.Services) != 1 {
+		return types.Service{}, fmt.Errorf("only a single ECS Service should be returned, %v found", len(service.Services))
+	}
+	return service.Services[0], nil
+}
+
+// lastSegment returns what's after the last sep in s, like the id at the end of an arn
+func lastSegment(s string, sep string) string {
+	return s[strings.LastIndex(s, sep)+1:]
+}
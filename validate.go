@@ -0,0 +1,413 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// the config reference doubles as the schema configs are validated against
+//
+//go:embed docs/config-reference.yml
+var configReference string
+
+// schemaKey is an entry in the config reference
+type schemaKey struct {
+	Type         string   `yaml:"type"`
+	Enum         []string `yaml:"enum"`
+	Required     bool     `yaml:"required"`      // must be set whenever its parent is
+	RequiredWith []string `yaml:"required_with"` // must be set whenever any of these are
+}
+
+// schemaNode is a key in the config, its children are keyed by name, [X] for any name and [] for list items
+type schemaNode struct {
+	key      *schemaKey
+	children map[string]*schemaNode
+}
+
+// problem is something wrong with the config at path, where list items are like containers.app.ports[0]
+type problem struct {
+	path []string
+	msg  string
+}
+
+func (c command) validateYeet(args []string, region string) int {
+	values, org, err := readValuesWithOrigins(defaults, args, region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read values: %v", err)
+		return 1
+	}
+	pp, err := validateValues(values)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cant validate config: %v\n", err)
+		return 1
+	}
+	if len(pp) > 0 {
+		printProblems(pp, org)
+		return 1
+	}
+	checkTaskRole(values, os.Stderr)
+	fmt.Println("Config is valid")
+	return 0
+}
+
+// validateValues checks read values against the schema and returns every problem found, sorted by path
+func validateValues(values map[string]interface{}) ([]problem, error) {
+	var keys map[string]schemaKey
+	if err := yaml.Unmarshal([]byte(configReference), &keys); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config reference: %v", err)
+	}
+	root := &schemaNode{children: map[string]*schemaNode{}}
+	for k := range keys {
+		sk := keys[k]
+		n := root
+		for _, t := range schemaTokens(k) {
+			if n.children[t] == nil {
+				n.children[t] = &schemaNode{children: map[string]*schemaNode{}}
+			}
+			n = n.children[t]
+		}
+		n.key = &sk
+	}
+
+	pp := checkValue(values, root, nil)
+	for k, sk := range keys {
+		if sk.Required {
+			pp = append(pp, checkRequired(values, schemaTokens(k), nil, "required key is missing")...)
+		}
+		for _, w := range sk.RequiredWith {
+			if !isEmpty(getValue(values, w)) {
+				pp = append(pp, checkRequired(values, schemaTokens(k), nil, fmt.Sprintf("required when %s is set", w))...)
+				break
+			}
+		}
+	}
+	pp = append(pp, checkMounts(values)...)
+	pp = append(pp, checkCapacityProviders(values)...)
+	pp = append(pp, checkFargateSize(values)...)
+	pp = append(pp, checkScaling(values)...)
+	pp = append(pp, checkSchedules(values)...)
+	pp = append(pp, checkLoadBalancers(values)...)
+	pp = append(pp, checkBlueGreen(values)...)
+	pp = append(pp, checkServiceConnect(values)...)
+	pp = append(pp, checkSecurityGroups(values)...)
+	sort.SliceStable(pp, func(i, j int) bool { return joinPath(pp[i].path) < joinPath(pp[j].path) })
+	return pp, nil
+}
+
+// printProblems writes each problem along with where the value came from
+func printProblems(pp []problem, org origins) {
+	for _, p := range pp {
+		// list items come from wherever their list did
+		keys := []string{}
+		for _, k := range p.path {
+			if strings.HasPrefix(k, "[") {
+				break
+			}
+			keys = append(keys, k)
+		}
+		if o := org.of(keys); o != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", o, joinPath(p.path), p.msg)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s\n", joinPath(p.path), p.msg)
+	}
+	fmt.Fprintf(os.Stderr, "%d problem(s) found in config\n", len(pp))
+}
+
+// checkValue checks v and everything under it against the schema node n
+func checkValue(v interface{}, n *schemaNode, path []string) []problem {
+	kind, typ := "", "map"
+	if n.key != nil {
+		kind, typ = schemaKind(n.key.Type), n.key.Type
+	}
+	if n.key == nil && n.children["[]"] != nil {
+		typ = "list"
+	}
+
+	if m := assertMSI(v); m != nil {
+		if kind != "" && kind != "map" && kind != "any" {
+			return []problem{{path, fmt.Sprintf("expected %s, got a map", typ)}}
+		}
+		if len(n.children) == 0 {
+			// free-form map, like environment or tags
+			return nil
+		}
+		pp := []problem{}
+		for _, k := range sortedKeys(m) {
+			if strings.HasPrefix(k, "_") {
+				// _include and friends are handled while reading the config
+				continue
+			}
+			child := n.children[k]
+			if child == nil {
+				child = n.children["[X]"]
+			}
+			if child == nil {
+				pp = append(pp, problem{subPath(path, k), "unknown key"})
+				continue
+			}
+			pp = append(pp, checkValue(m[k], child, subPath(path, k))...)
+		}
+		return pp
+	}
+
+	if l, ok := v.([]interface{}); ok {
+		item := n.children["[]"]
+		if item == nil && kind != "list" && kind != "any" {
+			return []problem{{path, fmt.Sprintf("expected %s, got a list", typ)}}
+		}
+		pp := []problem{}
+		for i, iv := range l {
+			ip := subPath(path, fmt.Sprintf("[%d]", i))
+			if item != nil {
+				pp = append(pp, checkValue(iv, item, ip)...)
+				continue
+			}
+			// lists without a schema for their items are either of maps or of scalars
+			_, isList := iv.([]interface{})
+			isMap := assertMSI(iv) != nil
+			if strings.Contains(strings.ToLower(typ), "of map") {
+				if !isMap {
+					pp = append(pp, problem{ip, fmt.Sprintf("expected a map in %s", typ)})
+				}
+				continue
+			}
+			if isMap || isList {
+				pp = append(pp, problem{ip, fmt.Sprintf("expected a single value in %s", typ)})
+				continue
+			}
+			pp = append(pp, checkEnum(iv, n.key, ip)...)
+		}
+		return pp
+	}
+
+	switch kind {
+	case "", "map", "list":
+		return []problem{{path, fmt.Sprintf("expected %s, got %q", typ, fmt.Sprint(v))}}
+	}
+	if !scalarOK(v, kind) {
+		return []problem{{path, fmt.Sprintf("%q is not a valid %s", fmt.Sprint(v), typ)}}
+	}
+	return checkEnum(v, n.key, path)
+}
+
+// checkMounts reports container mounts of volumes which aren't in volumes, and EFS volumes
+// whose task security group rules have no VPC to be in
+func checkMounts(values map[string]interface{}) []problem {
+	pp := []problem{}
+	volumes := assertMSI(values["volumes"])
+	for _, name := range sortedKeys(volumes) {
+		if getValue(assertMSI(volumes[name]), "efs.security_group") != nil && isEmpty(getValue(values, "aws.vpc")) {
+			pp = append(pp, problem{[]string{"aws", "vpc"}, fmt.Sprintf("required when volumes.%s.efs.security_group is set", name)})
+			break
+		}
+	}
+	containers := assertMSI(values["containers"])
+	for _, name := range sortedKeys(containers) {
+		mounts := assertMSI(assertMSI(containers[name])["mounts"])
+		for _, v := range sortedKeys(mounts) {
+			if _, ok := volumes[v]; !ok {
+				pp = append(pp, problem{[]string{"containers", name, "mounts", v}, "no volume with this name in volumes"})
+			}
+		}
+	}
+	return pp
+}
+
+// checkTaskRole warns about features whose permissions are only granted on the role yeet creates, which
+// with aws.iam.role_arn fail once the tasks run unless that role already has them
+func checkTaskRole(values map[string]interface{}, warn io.Writer) {
+	role := getValue(values, "aws.iam.role_arn")
+	if role == nil {
+		return
+	}
+	needs := []string{}
+	volumes := assertMSI(values["volumes"])
+	for _, k := range sortedKeys(volumes) {
+		if fmt.Sprint(getValue(assertMSI(volumes[k]), "efs.iam")) == "true" {
+			needs = append(needs, fmt.Sprintf("volumes.%s.efs.iam needs elasticfilesystem:ClientMount and elasticfilesystem:ClientWrite on the file system", k))
+		}
+	}
+	if getValue(values, "scaling.queue") != nil {
+		needs = append(needs, "scaling.queue needs sqs:ReceiveMessage, sqs:DeleteMessage, sqs:ChangeMessageVisibility, sqs:GetQueueAttributes and sqs:GetQueueUrl on the queue")
+	}
+	if fmt.Sprint(getValue(values, "aws.ecs.enable_execute_command")) == "true" {
+		needs = append(needs, "aws.ecs.enable_execute_command needs the ssmmessages permissions ECS Exec uses")
+	}
+	for _, n := range needs {
+		fmt.Fprintf(warn, "warning: aws.iam.role_arn %v isn't given permissions by yeet, %s\n", role, n)
+	}
+}
+
+// checkCapacityProviders reports strategies ECS won't accept, only one provider can have a base
+// and at least one needs a weight
+func checkCapacityProviders(values map[string]interface{}) []problem {
+	cps := assertMSI(getValue(values, "aws.ecs.capacity_providers"))
+	if len(cps) == 0 {
+		return nil
+	}
+	path := []string{"aws", "ecs", "capacity_providers"}
+	pp := []problem{}
+	bases, weighted := []string{}, false
+	for _, name := range sortedKeys(cps) {
+		cp := assertMSI(cps[name])
+		if b, ok := cp["base"]; ok && fmt.Sprint(b) != "0" {
+			bases = append(bases, name)
+		}
+		if w, ok := cp["weight"]; ok && fmt.Sprint(w) != "0" {
+			weighted = true
+		}
+	}
+	if len(bases) > 1 {
+		pp = append(pp, problem{path, fmt.Sprintf("only one capacity provider can have a base, %s do", strings.Join(bases, ", "))})
+	}
+	if !weighted {
+		pp = append(pp, problem{path, "at least one capacity provider needs a weight above 0"})
+	}
+	return pp
+}
+
+// checkRequired reports the key at the end of tokens when it's missing from a parent that is set
+func checkRequired(v interface{}, tokens []string, path []string, msg string) []problem {
+	pp := []problem{}
+	switch tokens[0] {
+	case "[X]":
+		m := assertMSI(v)
+		for _, k := range sortedKeys(m) {
+			pp = append(pp, checkRequired(m[k], tokens[1:], subPath(path, k), msg)...)
+		}
+	case "[]":
+		l, _ := v.([]interface{})
+		for i, iv := range l {
+			pp = append(pp, checkRequired(iv, tokens[1:], subPath(path, fmt.Sprintf("[%d]", i)), msg)...)
+		}
+	default:
+		m := assertMSI(v)
+		if m == nil {
+			// the parent isn't set, or isn't a map which checkValue reports
+			return nil
+		}
+		child, ok := m[tokens[0]]
+		if len(tokens) == 1 {
+			if !ok || isEmpty(child) {
+				pp = append(pp, problem{subPath(path, tokens[0]), msg})
+			}
+			return pp
+		}
+		if !ok {
+			return nil
+		}
+		pp = append(pp, checkRequired(child, tokens[1:], subPath(path, tokens[0]), msg)...)
+	}
+	return pp
+}
+
+func checkEnum(v interface{}, sk *schemaKey, path []string) []problem {
+	if sk == nil || len(sk.Enum) == 0 {
+		return nil
+	}
+	s := fmt.Sprint(v)
+	for _, e := range sk.Enum {
+		if s == e {
+			return nil
+		}
+	}
+	return []problem{{path, fmt.Sprintf("%q is not one of %s", s, strings.Join(sk.Enum, ", "))}}
+}
+
+// schemaKind simplifies the config reference's types, which are written for people
+func schemaKind(t string) string {
+	t = strings.ToLower(t)
+	switch {
+	case strings.Contains(t, " or "):
+		// like String or Map, the children in the schema are checked when it's a map
+		return "any"
+	case strings.HasPrefix(t, "list"), strings.HasPrefix(t, "slice"):
+		return "list"
+	case strings.HasPrefix(t, "map"), strings.HasPrefix(t, "object"):
+		return "map"
+	case t == "integer", t == "double", t == "boolean":
+		return t
+	}
+	// strings take any single value, yaml is happy to make 12 a number
+	return "string"
+}
+
+// scalarOK checks v is the kind, allowing strings holding the value as that's what templated config produces
+func scalarOK(v interface{}, kind string) bool {
+	s := fmt.Sprint(v)
+	switch kind {
+	case "integer":
+		_, err := strconv.ParseInt(s, 10, 64)
+		return err == nil
+	case "double":
+		_, err := strconv.ParseFloat(s, 64)
+		return err == nil
+	case "boolean":
+		_, err := strconv.ParseBool(s)
+		return err == nil
+	}
+	return true
+}
+
+// schemaTokens splits a config reference key like containers[X].depends_on[].condition into
+// containers, [X], depends_on, [], condition
+func schemaTokens(key string) []string {
+	tt := []string{}
+	for _, part := range strings.Split(key, ".") {
+		name, rest, ok := strings.Cut(part, "[")
+		tt = append(tt, name)
+		if ok {
+			tt = append(tt, "["+rest)
+		}
+	}
+	return tt
+}
+
+// joinPath joins keys with dots, except list indexes which follow their list
+func joinPath(path []string) string {
+	s := ""
+	for i, k := range path {
+		if i > 0 && !strings.HasPrefix(k, "[") {
+			s += "."
+		}
+		s += k
+	}
+	return s
+}
+
+// subPath returns a copy of path with k on the end, so sibling paths don't share a backing array
+func subPath(path []string, k string) []string {
+	p := make([]string, len(path), len(path)+1)
+	copy(p, path)
+	return append(p, k)
+}
+
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	if m := assertMSI(v); m != nil {
+		return len(m) == 0
+	}
+	if l, ok := v.([]interface{}); ok {
+		return len(l) == 0
+	}
+	return fmt.Sprint(v) == ""
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	kk := []string{}
+	for k := range m {
+		kk = append(kk, k)
+	}
+	sort.Strings(kk)
+	return kk
+}
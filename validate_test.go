@@ -0,0 +1,220 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// the smallest config with every required key, each case adds to it
+const validBase = `
+name: app
+aws:
+  ecs:
+    task:
+      subnets: [subnet-1]
+containers:
+  app:
+    image: nginx
+`
+
+// validate returns the problems with base and config merged, as path: msg
+func validate(t *testing.T, config string) []string {
+	t.Helper()
+	var values, base map[string]interface{}
+	if err := yaml.Unmarshal([]byte(config), &values); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal([]byte(validBase), &base); err != nil {
+		t.Fatal(err)
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	// the case's config wins, the same as config files over defaults
+	values, err := mergeKeys(values, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values = deleteNulls(values)
+	pp, err := validateValues(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := []string{}
+	for _, p := range pp {
+		ss = append(ss, joinPath(p.path)+": "+p.msg)
+	}
+	return ss
+}
+
+func TestValidateValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string // empty for a valid config
+	}{
+		{
+			name: "valid",
+		},
+		{
+			name:   "unknown key",
+			config: "aws: {ecs: {bogus: 1}}",
+			want:   []string{"aws.ecs.bogus: unknown key"},
+		},
+		{
+			name:   "unknown key under a name",
+			config: "containers: {app: {imgae: nginx}}",
+			want:   []string{"containers.app.imgae: unknown key"},
+		},
+		{
+			name:   "underscore keys are skipped",
+			config: "_include: [other.yml]\ncontainers: {_defaults: {bogus: 1}}",
+		},
+		{
+			name:   "enum",
+			config: "aws: {ecs: {deployment: {strategy: sideways}}}",
+			want:   []string{`aws.ecs.deployment.strategy: "sideways" is not one of rolling, blue_green`},
+		},
+		{
+			name:   "integer from a templated string",
+			config: `scaling: {max: "3"}`,
+		},
+		{
+			name:   "integer",
+			config: "scaling: {max: three}",
+			want:   []string{`scaling.max: "three" is not a valid Integer`},
+		},
+		{
+			name:   "boolean from a templated string",
+			config: `containers: {app: {essential: "false"}}`,
+		},
+		{
+			name:   "boolean",
+			config: "containers: {app: {essential: maybe}}",
+			want:   []string{`containers.app.essential: "maybe" is not a valid Boolean`},
+		},
+		{
+			name:   "map where a value is expected",
+			config: "name: {first: app}",
+			want:   []string{"name: expected String, got a map"},
+		},
+		{
+			name:   "any name path",
+			config: "volumes: {data: {}}\ncontainers: {app: {mounts: {data: {readonly: true}}}}",
+			want:   []string{"containers.app.mounts.data.path: required key is missing"},
+		},
+		{
+			name:   "list item path",
+			config: "containers: {app: {depends_on: [{container: db, condition: START}, {container: db, condition: READY}]}}",
+			want:   []string{`containers.app.depends_on[1].condition: "READY" is not one of START, COMPLETE, SUCCESS, HEALTHY`},
+		},
+		{
+			name:   "required",
+			config: "name: ~",
+			want:   []string{"name: required key is missing"},
+		},
+		{
+			name:   "required under a set parent",
+			config: "scaling: {queue: {name: jobs}}",
+			want:   []string{"scaling.queue.backlog_per_task: required key is missing"},
+		},
+		{
+			name:   "queue in another region",
+			config: "aws: {region: ap-southeast-2}\nscaling: {queue: {name: 'arn:aws:sqs:us-east-1:123456789012:jobs', backlog_per_task: 10}}",
+			want:   []string{"scaling.queue.name: the queue has to be in the stack's region ap-southeast-2 to scale on its metrics"},
+		},
+		{
+			name:   "queue in the stack's region",
+			config: "aws: {region: ap-southeast-2}\nscaling: {queue: {name: 'arn:aws:sqs:ap-southeast-2:123456789012:jobs', backlog_per_task: 10}}",
+		},
+		{
+			name:   "security group peers",
+			config: "aws: {vpc: vpc-1, ecs: {task: {ingress: {web: {ports: 80, protocol: tcp, allow_ingress_from: [10.0.0.0/8, '::/0', sg-1, pl-1, stack:web]}}}}}",
+		},
+		{
+			name:   "bare name as a security group peer",
+			config: "aws: {vpc: vpc-1, ecs: {task: {egress: {db: {ports: 5432, protocol: tcp, allow_egress_to: [other-stack]}}}}}",
+			want:   []string{`aws.ecs.task.egress.db.allow_egress_to[0]: "other-stack" is not a CIDR, security group id, prefix list id or stack:<name>`},
+		},
+		{
+			name:   "required with",
+			config: "aws: {ecs: {task: {ingress: {web: {ports: 80, protocol: tcp, allow_ingress_from: [10.0.0.0/8]}}}}}",
+			want:   []string{"aws.vpc: required when aws.ecs.task.ingress is set"},
+		},
+		{
+			name:   "vpc for a created target group",
+			config: "aws: {application_load_balancers: {web: {container: {port: 80}, load_balancer: {subnets: [a]}}}}",
+			want:   []string{"aws.vpc: required when aws.application_load_balancers.web has no target_group"},
+		},
+		{
+			name:   "no vpc for a byo target group",
+			config: "aws: {application_load_balancers: {web: {container: {port: 80}, target_group: arn:tg}}}",
+		},
+		{
+			name:   "vpc for an efs security group",
+			config: "volumes: {data: {efs: {file_system_id: fs-1, security_group: sg-efs}}}",
+			want:   []string{"aws.vpc: required when volumes.data.efs.security_group is set"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validate(t, tt.config)
+			if len(tt.want) == 0 && len(got) > 0 {
+				t.Fatalf("expected no problems, got:\n%s", strings.Join(got, "\n"))
+			}
+			for _, w := range tt.want {
+				found := false
+				for _, g := range got {
+					if g == w {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("missing problem %q, got:\n%s", w, strings.Join(got, "\n"))
+				}
+			}
+		})
+	}
+}
+
+func TestSchemaTokens(t *testing.T) {
+	got := strings.Join(schemaTokens("containers[X].depends_on[].condition"), " ")
+	want := "containers [X] depends_on [] condition"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCheckTaskRole(t *testing.T) {
+	values := map[string]interface{}{
+		"aws": map[interface{}]interface{}{
+			"iam": map[interface{}]interface{}{"role_arn": "my-role"},
+			"ecs": map[interface{}]interface{}{"enable_execute_command": true},
+		},
+		"scaling": map[interface{}]interface{}{"queue": map[interface{}]interface{}{"name": "jobs"}},
+		"volumes": map[interface{}]interface{}{
+			"data": map[interface{}]interface{}{"efs": map[interface{}]interface{}{"file_system_id": "fs-1", "iam": true}},
+			"logs": map[interface{}]interface{}{"efs": map[interface{}]interface{}{"file_system_id": "fs-1"}},
+		},
+	}
+	var w strings.Builder
+	checkTaskRole(values, &w)
+	got := w.String()
+	for _, want := range []string{"volumes.data.efs.iam", "scaling.queue", "aws.ecs.enable_execute_command"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing warning for %s, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "volumes.logs") {
+		t.Errorf("unexpected warning for volumes.logs, got:\n%s", got)
+	}
+
+	w.Reset()
+	delete(values, "aws")
+	checkTaskRole(values, &w)
+	if w.Len() > 0 {
+		t.Errorf("expected no warnings without aws.iam.role_arn, got:\n%s", w.String())
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/toolsdotgo/sfm/pkg/sfm"
+)
+
+// serviceWatcher prints what the stack's ECS Service is doing while a deploy waits on the stack
+type serviceWatcher struct {
+	client   *ecs.Client
+	cluster  string
+	service  string              // service arn, found from the stack once it exists
+	since    time.Time           // nothing that happened before the watcher started is printed
+	last     time.Time           // when the service was last polled
+	events   map[string]struct{} // service events already printed
+	rollouts map[string]string   // deployment id to the last rollout state printed
+	counts   string              // task counts last printed
+	stopped  map[string]struct{} // stopped tasks already printed
+	err      string              // last error printed, so a failing poll doesn't repeat itself
+}
+
+// how often the service is polled, the stack is polled more often
+const serviceWatchInterval = 10 * time.Second
+
+func newServiceWatcher(cluster string) *serviceWatcher {
+	return &serviceWatcher{
+		client:   ecs.NewFromConfig(cfg),
+		cluster:  cluster,
+		since:    time.Now(),
+		events:   map[string]struct{}{},
+		rollouts: map[string]string{},
+		stopped:  map[string]struct{}{},
+	}
+}
+
+// poll prints new service events, rollout state and task count changes, and stopped tasks
+func (w *serviceWatcher) poll(s sfm.Stack) {
+	if time.Since(w.last) < serviceWatchInterval {
+		return
+	}
+	w.last = time.Now()
+	if err := w.pollService(s); err != nil && err.Error() != w.err {
+		w.err = err.Error()
+		fmt.Fprintf(os.Stderr, "cant watch service: %v\n", err)
+	}
+}
+
+func (w *serviceWatcher) pollService(s sfm.Stack) error {
+	if w.service == "" {
+		w.service = s.Outputs["Service"]
+	}
+	if w.service == "" {
+		// the service doesn't have an output until a new stack is complete
+		rr, err := s.Resources()
+		if err != nil {
+			return err
+		}
+		w.service = rr["Service"]["pid"]
+	}
+	if w.service == "" {
+		return nil
+	}
+
+	in := &ecs.DescribeServicesInput{Services: []string{w.service}}
+	if w.cluster != "" {
+		in.Cluster = aws.String(w.cluster)
+	}
+	out, err := w.client.DescribeServices(context.TODO(), in)
+	if err != nil {
+		return fmt.Errorf("failed to get service: %v", err)
+	}
+	if len(out.Services) != 1 {
+		return nil
+	}
+	svc := out.Services[0]
+
+	// events are newest first
+	for i := len(svc.Events) - 1; i >= 0; i-- {
+		e := svc.Events[i]
+		id := aws.ToString(e.Id)
+		if _, ok := w.events[id]; ok || aws.ToTime(e.CreatedAt).Before(w.since) {
+			continue
+		}
+		w.events[id] = struct{}{}
+		w.print(aws.ToTime(e.CreatedAt), "SERVICE_EVENT", aws.ToString(e.Message))
+	}
+
+	for _, d := range svc.Deployments {
+		id := aws.ToString(d.Id)
+		state := string(d.RolloutState)
+		if w.rollouts[id] == state {
+			continue
+		}
+		w.rollouts[id] = state
+		msg := fmt.Sprintf("%s deployment of %s", aws.ToString(d.Status), lastSegment(aws.ToString(d.TaskDefinition), "/"))
+		if d.RolloutStateReason != nil {
+			msg = fmt.Sprintf("%s: %s", msg, *d.RolloutStateReason)
+		}
+		w.print(time.Now(), state, msg)
+	}
+
+	counts := fmt.Sprintf("%d running, %d pending, %d desired", svc.RunningCount, svc.PendingCount, svc.DesiredCount)
+	if counts != w.counts {
+		w.counts = counts
+		w.print(time.Now(), "TASK_COUNT", counts)
+	}
+
+	return w.pollStopped(svc)
+}
+
+// pollStopped prints why tasks stopped since the watcher started, e.g. failed image pulls or health checks
+func (w *serviceWatcher) pollStopped(svc types.Service) error {
+	list, err := w.client.ListTasks(context.TODO(), &ecs.ListTasksInput{
+		Cluster:       svc.ClusterArn,
+		ServiceName:   svc.ServiceName,
+		DesiredStatus: types.DesiredStatusStopped,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get stopped task ARNs: %v", err)
+	}
+	arns := []string{}
+	for _, arn := range list.TaskArns {
+		if _, ok := w.stopped[arn]; !ok {
+			arns = append(arns, arn)
+		}
+	}
+	if len(arns) < 1 {
+		return nil
+	}
+	tasks, err := w.client.DescribeTasks(context.TODO(), &ecs.DescribeTasksInput{
+		Cluster: svc.ClusterArn,
+		Tasks:   arns,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe stopped tasks: %v", err)
+	}
+	sort.Slice(tasks.Tasks, func(i, j int) bool {
+		return aws.ToTime(tasks.Tasks[i].StoppingAt).Before(aws.ToTime(tasks.Tasks[j].StoppingAt))
+	})
+	for _, t := range tasks.Tasks {
+		arn := aws.ToString(t.TaskArn)
+		if aws.ToTime(t.StoppingAt).Before(w.since) {
+			w.stopped[arn] = struct{}{}
+			continue
+		}
+		if t.StoppedAt == nil {
+			// not stopped yet, the reasons aren't final
+			continue
+		}
+		w.stopped[arn] = struct{}{}
+		reasons := []string{}
+		for _, ct := range t.Containers {
+			if ct.Reason == nil && ct.ExitCode == nil {
+				continue
+			}
+			exit := "-"
+			if ct.ExitCode != nil {
+				exit = fmt.Sprint(*ct.ExitCode)
+			}
+			reasons = append(reasons, fmt.Sprintf("%s exit %s %s", aws.ToString(ct.Name), exit, aws.ToString(ct.Reason)))
+		}
+		msg := fmt.Sprintf("task %s %s: %s", lastSegment(arn, "/"), t.StopCode, aws.ToString(t.StoppedReason))
+		if len(reasons) > 0 {
+			msg = fmt.Sprintf("%s (%s)", msg, strings.Join(reasons, "; "))
+		}
+		w.print(*t.StoppedAt, "TASK_STOPPED", msg)
+	}
+	return nil
+}
+
+func (w *serviceWatcher) print(t time.Time, status string, msg string) {
+	printWatch(t, "ECS Service", status, msg)
+}
+
+// printWatch lines up with the stack events printed by sfm's Event.Pretty
+func printWatch(t time.Time, resource string, status string, msg string) {
+	loc, _ := time.LoadLocation("Local") // WARN this might break on non-UNIX systems
+	if len(status) > 20 {
+		status = status[0:17] + "..."
+	}
+	fmt.Printf("%s %-30s %-20s %s\n", t.In(loc).Format("15:04:05 MST"), resource, status, msg)
+}
@@ -1,4 +1,11 @@
 {{$r := rand 5 -}}
+{{$secrets := false}}{{range $.containers}}{{if .secrets}}{{$secrets = true}}{{end}}{{end -}}
+{{$execrole := and $secrets (not $.aws.ecs.task.execution_role) -}}
+{{$tasksg := or $.aws.ecs.task.ingress $.aws.ecs.task.egress}}{{$efsiam := false -}}
+{{range $.volumes}}{{with .efs}}{{if .security_group}}{{$tasksg = true}}{{end}}{{if .iam}}{{$efsiam = true}}{{end}}{{end}}{{end -}}
+{{$albcreated := false}}{{range $.aws.application_load_balancers}}{{with .load_balancer}}{{$albcreated = true}}{{if not .security_groups}}{{$tasksg = true}}{{end}}{{end}}{{end -}}
+{{$bluegreen := eq (print $.aws.ecs.deployment.strategy) "blue_green"}}{{$tgnames := list "TargetGroup"}}{{if $bluegreen}}{{$tgnames = list "TargetGroup" "GreenTargetGroup"}}{{end -}}
+{{$cdk := ""}}{{$cdv := false}}{{if $bluegreen}}{{range $k, $v := $.aws.application_load_balancers}}{{if not $cdk}}{{$cdk = $k}}{{$cdv = $v}}{{end}}{{end}}{{end -}}
 ---
 AWSTemplateFormatVersion: "2010-09-09"
 Description: Template for {{$.name}}
@@ -19,7 +26,9 @@ Resources:
           {{range $c.ports}}
           {{range $protocol, $port := .}}
             - ContainerPort: {{$port}}
-              Protocol: {{$protocol}}{{end}}
+              Protocol: {{$protocol}}{{with serviceconnectport $.aws.service_connect $name $port}}
+              Name: '{{.}}'{{with (index $.aws.service_connect.services .).app_protocol}}
+              AppProtocol: {{.}}{{end}}{{end}}{{end}}
           {{end}}
           {{end}}
           {{if $c.environment}}
@@ -28,6 +37,12 @@ Resources:
             - Name: '{{$k}}'
               Value: '{{$v}}'{{end}}
           {{end}}
+          {{if $c.secrets}}
+          Secrets:
+          {{range $k, $v := $c.secrets}}
+            - Name: '{{$k}}'
+              ValueFrom: {{secretfrom $v}}{{end}}
+          {{end}}
           Essential: {{$c.essential}}
           ReadonlyRootFilesystem: {{$c.readonly}}
           LogConfiguration:
@@ -36,7 +51,7 @@ Resources:
               awslogs-group: {{with $c.logs.group}}{{.}}{{else}}!Ref ServiceLogGroup{{end}}
               awslogs-stream-prefix: '{{with $c.logs.prefix}}{{.}}{{else}}{{$name}}{{end}}'
               awslogs-datetime-format: '{{$c.logs.datetime}}'
-              awslogs-region: '{{$c.logs.region}}'
+              {{with $c.logs.region}}awslogs-region: '{{.}}'{{end}}
           {{if $c.ulimits}}
           Ulimits:
           {{range $k, $v := $c.ulimits}}
@@ -51,6 +66,13 @@ Resources:
             - SourceContainer: {{.container}}
               ReadOnly: {{with .readonly}}{{.}}{{else}}false{{end}}{{end}}
           {{end}}
+          {{if $c.mounts}}
+          MountPoints:
+          {{range $k, $v := $c.mounts}}
+            - SourceVolume: '{{$k}}'
+              ContainerPath: '{{$v.path}}'
+              ReadOnly: {{with $v.readonly}}{{.}}{{else}}false{{end}}{{end}}
+          {{end}}
           {{if $c.depends_on}}
           DependsOn:
           {{range $c.depends_on }}
@@ -71,39 +93,86 @@ Resources:
 	  {{end}}
       {{end}}
       Cpu: {{$.aws.ecs.task.cpu}}
-      ExecutionRoleArn: {{$.aws.ecs.task.execution_role}}
+      ExecutionRoleArn: {{if $execrole}}!GetAtt ExecutionRole.Arn{{else}}{{$.aws.ecs.task.execution_role}}{{end}}
       Memory: {{$.aws.ecs.task.memory}}
       NetworkMode: awsvpc
+      {{if or $.aws.ecs.task.architecture $.aws.ecs.task.os_family}}
+      RuntimePlatform:
+        {{if $.aws.ecs.task.architecture}}CpuArchitecture: {{$.aws.ecs.task.architecture}}{{end}}
+        {{if $.aws.ecs.task.os_family}}OperatingSystemFamily: {{$.aws.ecs.task.os_family}}{{end}}
+      {{end}}
       TaskRoleArn: {{if $.aws.iam.role_arn}}{{$.aws.iam.role_arn}}{{else}}!Ref Role{{end}}
+      {{if $.volumes}}
+      Volumes:
+      {{range $k, $v := $.volumes}}
+        - Name: '{{$k}}'
+          {{with $v.efs}}
+          EFSVolumeConfiguration:
+            FilesystemId: {{.file_system_id}}
+            {{if not .access_point}}{{with .root_directory}}RootDirectory: '{{.}}'{{end}}{{end}}
+            {{/* access points and iam auth only work over tls, so it's on unless turned off */}}
+            TransitEncryption: {{if or .access_point .iam (ne (print .transit_encryption) "false")}}ENABLED{{else}}DISABLED{{end}}
+            {{if or .access_point .iam}}
+            AuthorizationConfig:
+              {{with .access_point}}AccessPointId: {{.}}{{end}}
+              IAM: {{if .iam}}ENABLED{{else}}DISABLED{{end}}
+            {{end}}
+          {{end}}
+      {{end}}
+      {{end}}
   Service:
     Type: AWS::ECS::Service
-    {{if not $.aws.iam.role_arn}}
+    {{if or (not $.aws.iam.role_arn) $secrets $albcreated}}
     DependsOn:
+      {{if not $.aws.iam.role_arn}}
       - Role
       {{range $k, $v := $.aws.network_load_balancers}}
       - {{logicalid $k "NLB"}}
       - {{logicalid $k "Listener"}}
       {{end}}
+      {{end}}
+      {{if $secrets}}
+      - ExecutionRoleSecretsPolicy
+      {{end}}
+      {{range $k, $v := $.aws.application_load_balancers}}{{if $v.load_balancer}}
+      - {{logicalid $k "Listener"}}
+      {{end}}{{end}}
     {{end}}
     Properties:
       Cluster: {{$.aws.ecs.cluster}}
+      {{if $bluegreen}}
+      DeploymentController:
+        Type: CODE_DEPLOY
+      {{else}}
       DeploymentConfiguration:
         DeploymentCircuitBreaker:
           Enable: True
           Rollback: True
+      {{end}}
       DesiredCount: {{$.scaling.desired}}
+      {{if $.aws.ecs.enable_execute_command}}EnableExecuteCommand: true{{end}}
+      {{if $.aws.ecs.capacity_providers}}
+      CapacityProviderStrategy:
+      {{range $k, $v := $.aws.ecs.capacity_providers}}
+        - CapacityProvider: {{$k}}
+          {{with $v.base}}Base: {{.}}{{end}}
+          Weight: {{with $v.weight}}{{.}}{{else}}0{{end}}
+      {{end}}
+      {{else}}
       LaunchType: FARGATE
+      {{end}}
       PlatformVersion: {{$.aws.ecs.platform_version}}
       PropagateTags: TASK_DEFINITION
-      TaskDefinition: !Ref TaskDefinition
+      {{/* blue/green services stay on the task definition CodeDeploy moved them to, see pinServiceTaskDefinition */}}
+      TaskDefinition: {{with $._service_task_definition}}'{{.}}'{{else}}{{with $.aws.ecs.task_definition}}'{{.}}'{{else}}!Ref TaskDefinition{{end}}{{end}}
       NetworkConfiguration:
         AwsvpcConfiguration:
           {{with $.aws.ecs.task.assign_public_ip}}
           AssignPublicIp: {{.}}
           {{end}}
-          {{if or (or $.aws.ecs.task.ingress $.aws.ecs.task.egress) $.aws.ecs.task.security_groups}}
+          {{if or $tasksg $.aws.ecs.task.security_groups}}
           SecurityGroups:
-          {{if or $.aws.ecs.task.ingress $.aws.ecs.task.egress}}
+          {{if $tasksg}}
             - !GetAtt TaskSG.GroupId
           {{end}}
           {{if $.aws.ecs.task.security_groups}}
@@ -135,8 +204,39 @@ Resources:
         - RegistryArn: {{with $.aws.service_discovery.cloudmap.service}}!Sub 'arn:aws:servicediscovery:${AWS::Region}:${AWS::AccountId}:service/{{.}}'{{else}}!GetAtt CloudMapService.Arn{{end}}
           ContainerName: {{$.aws.service_discovery.cloudmap.container}}
       {{end}}
+      {{with $.aws.service_connect}}
+      ServiceConnectConfiguration:
+        Enabled: true
+        Namespace: '{{.namespace}}'
+        {{with .services}}
+        Services:
+        {{range $k, $v := .}}
+          - PortName: '{{$k}}'
+            {{with $v.discovery_name}}DiscoveryName: '{{.}}'{{end}}
+            {{with $v.client_aliases}}
+            ClientAliases:
+            {{range .}}
+              - Port: {{.port}}
+                {{with .dns_name}}DnsName: '{{.}}'{{end}}
+            {{end}}
+            {{end}}
+            {{with $v.timeout}}
+            Timeout:
+              {{with .idle}}IdleTimeoutSeconds: {{.}}{{end}}
+              {{with .per_request}}PerRequestTimeoutSeconds: {{.}}{{end}}
+            {{end}}
+            {{with $v.tls}}
+            Tls:
+              IssuerCertificateAuthority:
+                AwsPcaAuthorityArn: {{.ca_arn}}
+              {{with .kms_key}}KmsKey: {{.}}{{end}}
+              {{with .role_arn}}RoleArn: {{.}}{{end}}
+            {{end}}
+        {{end}}
+        {{end}}
+      {{end}}
 
-{{if or $.aws.ecs.task.ingress $.aws.ecs.task.egress}}
+{{if $tasksg}}
   TaskSG:
     Type: AWS::EC2::SecurityGroup
     Properties:
@@ -145,8 +245,8 @@ Resources:
       {{with $.aws.ecs.task.ingress}}
       SecurityGroupIngress:
       {{range $k, $v := .}}
-      {{range $source := $v.allow_ingress_from}} {{/* TODO: add support for using security groups as source */}}
-        - CidrIp{{if contains $source ":"}}v6{{end}}: {{$source}}
+      {{range $source := $v.allow_ingress_from}}
+        - {{sgpeer $source "Source"}}
           Description: "{{$k}}: {{$v.description}}"
           {{with $v.ports}}
           FromPort: {{rangestart .}}
@@ -162,8 +262,8 @@ Resources:
       {{with $.aws.ecs.task.egress}}
       SecurityGroupEgress:
       {{range $k, $v := .}}
-      {{range $dest := $v.allow_egress_to}} {{/* TODO: add support for using security groups as destination */}}
-        - CidrIp{{if contains $dest ":"}}v6{{end}}: {{$dest}}
+      {{range $dest := $v.allow_egress_to}}
+        - {{sgpeer $dest "Destination"}}
           Description: "{{$k}}: {{$v.description}}"
           {{with $v.ports}}
           FromPort: {{rangestart .}}
@@ -177,6 +277,26 @@ Resources:
       {{end}}
       {{end}}
 {{end}}
+{{range efssecuritygroups $.volumes}}
+  {{logicalid . "EFSIngress"}}:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: {{.}}
+      Description: NFS from {{$.name}} tasks
+      IpProtocol: tcp
+      FromPort: 2049
+      ToPort: 2049
+      SourceSecurityGroupId: !GetAtt TaskSG.GroupId
+  {{logicalid . "EFSEgress"}}:
+    Type: AWS::EC2::SecurityGroupEgress
+    Properties:
+      GroupId: !GetAtt TaskSG.GroupId
+      Description: NFS to EFS
+      IpProtocol: tcp
+      FromPort: 2049
+      ToPort: 2049
+      DestinationSecurityGroupId: {{.}}
+{{end}}
 
 {{if $.aws.service_discovery}}
 {{if $.aws.service_discovery.cloudmap.namespace}}
@@ -215,8 +335,57 @@ Resources:
       {{end}}
       {{end}}
       {{with $.aws.iam.role.path}}Path: {{.}}{{end}}
-      {{if $.aws.iam.role.policy_statements}}
+      {{if or $.aws.iam.role.policy_statements $efsiam $.scaling.queue $.aws.ecs.enable_execute_command}}
       Policies:
+      {{if $.aws.ecs.enable_execute_command}}
+        - PolicyName: ExecuteCommand
+          PolicyDocument:
+            Version: '2012-10-17'
+            Statement:
+              - Effect: Allow
+                Action:
+                  - ssmmessages:CreateControlChannel
+                  - ssmmessages:CreateDataChannel
+                  - ssmmessages:OpenControlChannel
+                  - ssmmessages:OpenDataChannel
+                Resource: '*'
+      {{end}}
+      {{with $.scaling.queue}}
+        - PolicyName: Queue
+          PolicyDocument:
+            Version: '2012-10-17'
+            Statement:
+              - Effect: Allow
+                Action:
+                  - sqs:ChangeMessageVisibility
+                  - sqs:DeleteMessage
+                  - sqs:GetQueueAttributes
+                  - sqs:GetQueueUrl
+                  - sqs:ReceiveMessage
+                Resource: {{queuearn .name}}
+              - Effect: Allow
+                Action: kms:Decrypt
+                Resource: '*'
+                Condition:
+                  StringEquals:
+                    kms:ViaService: !Sub 'sqs.${AWS::Region}.amazonaws.com'
+      {{end}}
+      {{range $k, $v := $.volumes}}{{with $v.efs}}{{if .iam}}
+        - PolicyName: {{logicalid $k "EFS"}}
+          PolicyDocument:
+            Version: '2012-10-17'
+            Statement:
+              - Effect: Allow
+                Action:
+                  - elasticfilesystem:ClientMount
+                  - elasticfilesystem:ClientWrite
+                Resource: !Sub 'arn:${AWS::Partition}:elasticfilesystem:${AWS::Region}:${AWS::AccountId}:file-system/{{.file_system_id}}'
+                {{with .access_point}}
+                Condition:
+                  StringEquals:
+                    elasticfilesystem:AccessPointArn: !Sub 'arn:${AWS::Partition}:elasticfilesystem:${AWS::Region}:${AWS::AccountId}:access-point/{{.}}'
+                {{end}}
+      {{end}}{{end}}{{end}}
       {{range $k, $v := $.aws.iam.role.policy_statements}}
         - PolicyName: {{$k}}
           PolicyDocument:
@@ -232,6 +401,123 @@ Resources:
       {{end}}
       {{end}}
 
+{{end}}
+{{if $execrole}}
+  ExecutionRole:
+    Type: AWS::IAM::Role
+    Properties:
+      AssumeRolePolicyDocument:
+        Version: 2012-10-17
+        Statement:
+          - Effect: Allow
+            Principal:
+              Service:
+                - ecs-tasks.amazonaws.com
+            Action: sts:AssumeRole
+      Description: ECS task execution role for {{$.name}}
+      ManagedPolicyArns:
+        - !Sub 'arn:${AWS::Partition}:iam::aws:policy/service-role/AmazonECSTaskExecutionRolePolicy'
+{{end}}
+{{if $secrets}}
+  ExecutionRoleSecretsPolicy:
+    Type: AWS::IAM::ManagedPolicy
+    Properties:
+      Description: Lets {{$.name}}'s execution role read its containers' secrets
+      Roles:
+        - {{if $execrole}}!Ref ExecutionRole{{else}}'{{suffix (print $.aws.ecs.task.execution_role) "/"}}'{{end}}
+      PolicyDocument:
+        Version: '2012-10-17'
+        Statement:
+          - Effect: Allow
+            Action:
+              - ssm:GetParameters
+              - secretsmanager:GetSecretValue
+            Resource:
+            {{range $name, $c := $.containers}}{{range $k, $v := $c.secrets}}
+              - {{secretarn $v}}{{end}}{{end}}
+          - Effect: Allow
+            Action: kms:Decrypt
+            Resource: '*'
+            Condition:
+              StringEquals:
+                kms:ViaService:
+                  - !Sub 'ssm.${AWS::Region}.amazonaws.com'
+                  - !Sub 'secretsmanager.${AWS::Region}.amazonaws.com'
+{{end}}
+
+{{if $.schedules}}
+  ScheduleRole:
+    Type: AWS::IAM::Role
+    Properties:
+      AssumeRolePolicyDocument:
+        Version: 2012-10-17
+        Statement:
+          - Effect: Allow
+            Principal:
+              Service:
+                - events.amazonaws.com
+            Action: sts:AssumeRole
+      Description: IAM role for EventBridge to run scheduled {{$.name}} tasks
+      Policies:
+        - PolicyName: RunTask
+          PolicyDocument:
+            Version: '2012-10-17'
+            Statement:
+              - Effect: Allow
+                Action: ecs:RunTask
+                Resource: !Sub 'arn:${AWS::Partition}:ecs:${AWS::Region}:${AWS::AccountId}:task-definition/{{$.name}}:*'
+              - Effect: Allow
+                Action: iam:PassRole
+                Resource:
+                  - {{if $execrole}}!GetAtt ExecutionRole.Arn{{else if contains (print $.aws.ecs.task.execution_role) "arn:"}}'{{$.aws.ecs.task.execution_role}}'{{else}}!Sub 'arn:${AWS::Partition}:iam::${AWS::AccountId}:role/{{$.aws.ecs.task.execution_role}}'{{end}}
+                  - {{if $.aws.iam.role_arn}}'{{$.aws.iam.role_arn}}'{{else}}!GetAtt Role.Arn{{end}}
+{{end}}
+
+{{range $k, $v := $.schedules}}
+  {{logicalid $k "Schedule"}}:
+    Type: AWS::Events::Rule
+    Properties:
+      Description: Runs {{$.name}} tasks for {{$k}}
+      ScheduleExpression: '{{$v.schedule}}'
+      State: {{if eq (print $v.enabled) "false"}}DISABLED{{else}}ENABLED{{end}}
+      Targets:
+        - Id: '{{logicalid $k}}'
+          Arn: {{if contains (print $.aws.ecs.cluster) "arn:"}}'{{$.aws.ecs.cluster}}'{{else}}!Sub 'arn:${AWS::Partition}:ecs:${AWS::Region}:${AWS::AccountId}:cluster/{{$.aws.ecs.cluster}}'{{end}}
+          RoleArn: !GetAtt ScheduleRole.Arn
+          {{with $v.overrides}}Input: {{taskoverrides .}}{{end}}
+          EcsParameters:
+            TaskDefinitionArn: {{with $.aws.ecs.task_definition}}'{{.}}'{{else}}!Ref TaskDefinition{{end}}
+            TaskCount: {{with $v.count}}{{.}}{{else}}1{{end}}
+            {{if $.aws.ecs.capacity_providers}}
+            CapacityProviderStrategy:
+            {{range $ck, $cv := $.aws.ecs.capacity_providers}}
+              - CapacityProvider: {{$ck}}
+                {{with $cv.base}}Base: {{.}}{{end}}
+                Weight: {{with $cv.weight}}{{.}}{{else}}0{{end}}
+            {{end}}
+            {{else}}
+            LaunchType: FARGATE
+            {{end}}
+            PlatformVersion: {{$.aws.ecs.platform_version}}
+            PropagateTags: TASK_DEFINITION
+            NetworkConfiguration:
+              AwsVpcConfiguration:
+                {{with $.aws.ecs.task.assign_public_ip}}
+                AssignPublicIp: {{.}}
+                {{end}}
+                {{if or $tasksg $.aws.ecs.task.security_groups}}
+                SecurityGroups:
+                {{if $tasksg}}
+                  - !GetAtt TaskSG.GroupId
+                {{end}}
+                {{range $.aws.ecs.task.security_groups}}
+                  - '{{.}}'
+                {{end}}
+                {{end}}
+                Subnets:
+                {{range $.aws.ecs.task.subnets}}
+                  - {{.}}
+                {{end}}
 {{end}}
 
   AutoScalingTarget:
@@ -244,6 +530,104 @@ Resources:
       ScalableDimension: ecs:service:DesiredCount
       ServiceNamespace: ecs
       RoleARN: !Sub 'arn:aws:iam::${AWS::AccountId}:role/aws-service-role/ecs.application-autoscaling.amazonaws.com/AWSServiceRoleForApplicationAutoScaling_ECSService'
+      {{if $.scaling.scheduled}}
+      ScheduledActions:
+      {{range $k, $v := $.scaling.scheduled}}
+        - ScheduledActionName: '{{$k}}'
+          Schedule: '{{$v.schedule}}'
+          {{with $v.timezone}}Timezone: '{{.}}'{{end}}
+          {{with $v.start}}StartTime: '{{.}}'{{end}}
+          {{with $v.end}}EndTime: '{{.}}'{{end}}
+          ScalableTargetAction:
+            {{if ne (print $v.min) "<nil>"}}MinCapacity: {{$v.min}}{{end}}
+            {{if ne (print $v.max) "<nil>"}}MaxCapacity: {{$v.max}}{{end}}
+      {{end}}
+      {{end}}
+
+{{with $.scaling.queue}}
+  QueueScalingPolicy:
+    Type: AWS::ApplicationAutoScaling::ScalingPolicy
+    Properties:
+      PolicyName: !Sub "${Service.Name}-queue"
+      PolicyType: TargetTrackingScaling
+      ScalingTargetId: !Ref AutoScalingTarget
+      TargetTrackingScalingPolicyConfiguration:
+        TargetValue: {{.backlog_per_task}}
+        ScaleInCooldown: {{with .scale_in_cooldown}}{{.}}{{else}}300{{end}}
+        ScaleOutCooldown: {{with .scale_out_cooldown}}{{.}}{{else}}60{{end}}
+        CustomizedMetricSpecification:
+          Metrics:
+            - Id: visible
+              MetricStat:
+                Metric:
+                  MetricName: ApproximateNumberOfMessagesVisible
+                  Namespace: AWS/SQS
+                  Dimensions:
+                    - Name: QueueName
+                      Value: '{{suffix (print .name) ":"}}'
+                Stat: Sum
+              ReturnData: false
+            - Id: tasks
+              MetricStat:
+                Metric:
+                  MetricName: RunningTaskCount
+                  Namespace: ECS/ContainerInsights
+                  Dimensions:
+                    - Name: ClusterName
+                      Value: '{{suffix (print $.aws.ecs.cluster) "/"}}'
+                    - Name: ServiceName
+                      Value: !GetAtt Service.Name
+                Stat: Average
+              ReturnData: false
+            - Id: backlog
+              Label: Backlog per task
+              Expression: visible / IF(tasks > 0, tasks, 1)
+              ReturnData: true
+{{end}}
+
+{{range $k, $v := $.scaling.target_tracking}}
+  {{logicalid $k "TargetTrackingPolicy"}}:
+    Type: AWS::ApplicationAutoScaling::ScalingPolicy
+    {{if eq $v.metric "requests"}}
+    {{$rk := albrule $.aws.application_load_balancers $v.load_balancer $v.listener_rule}}
+    DependsOn: {{if $rk}}{{logicalid $v.load_balancer $rk "ListenerRule"}}{{else}}{{logicalid $v.load_balancer "Listener"}}{{end}}
+    {{end}}
+    Properties:
+      PolicyName: !Sub "${Service.Name}-{{$k}}"
+      PolicyType: TargetTrackingScaling
+      ScalingTargetId: !Ref AutoScalingTarget
+      TargetTrackingScalingPolicyConfiguration:
+        TargetValue: {{$v.target}}
+        ScaleInCooldown: {{$v.scale_in_cooldown}}
+        ScaleOutCooldown: {{$v.scale_out_cooldown}}
+        DisableScaleIn: {{$v.disable_scale_in}}
+        {{if eq $v.metric "custom"}}
+        CustomizedMetricSpecification:
+          MetricName: "{{$v.when.metric}}"
+          Namespace: "{{$v.when.namespace}}"
+          Statistic: "{{$v.when.statistic}}"
+          {{with $v.when.unit}}Unit: {{.}}{{end}}
+          Dimensions:
+          {{if eq $v.when.namespace "AWS/ECS" "ECS/ContainerInsights" }}
+            - Name: ClusterName
+              Value: '{{$.aws.ecs.cluster}}'
+            - Name: ServiceName
+              Value: !GetAtt Service.Name
+          {{else}}
+          {{range $dk, $dv := $v.when.dimensions}}
+            - Name: {{$dk}}
+              Value: {{$dv}}
+          {{end}}
+          {{end}}
+        {{else}}
+        PredefinedMetricSpecification:
+          PredefinedMetricType: {{trackedmetric $v.metric}}
+          {{if eq $v.metric "requests"}}
+          {{$rk := albrule $.aws.application_load_balancers $v.load_balancer $v.listener_rule}}
+          ResourceLabel: !Sub '{{alblabel $.aws.application_load_balancers $v.load_balancer $rk}}/${ {{- logicalid $v.load_balancer "TargetGroup"}}.TargetGroupFullName}'
+          {{end}}
+        {{end}}
+{{end}}
 
 {{range $k, $v := $.scaling.step_scaling}}
   {{logicalid $k "ScalingAlarm"}}:
@@ -283,7 +667,14 @@ Resources:
         Cooldown: {{$v.cooldown}}
         MetricAggregationType: "{{$v.when.statistic}}"
         StepAdjustments:
-        {{if $v.adjustment}}
+        {{/* steps win over a single adjustment, which checkScaling doesn't allow both of */}}
+        {{if $v.steps}}
+        {{range $v.steps}}
+          - ScalingAdjustment: {{.adjustment}}
+            {{if ne (print .lower) "<nil>"}}MetricIntervalLowerBound: {{.lower}}{{end}}
+            {{if ne (print .upper) "<nil>"}}MetricIntervalUpperBound: {{.upper}}{{end}}
+        {{end}}
+        {{else if $v.adjustment}}
           - ScalingAdjustment: {{$v.adjustment}}
             {{if ge $v.adjustment 0}}
             MetricIntervalLowerBound: 0
@@ -343,8 +734,8 @@ Resources:
       {{end}}
 {{end}}
 {{range $k, $v := $.aws.application_load_balancers}}
-{{if not $v.target_group}}
-  {{logicalid $k "TargetGroup"}}:
+{{if not $v.target_group}}{{range $tg := $tgnames}}
+  {{logicalid $k $tg}}:
     Type: AWS::ElasticLoadBalancingV2::TargetGroup
     Properties:
       HealthCheckEnabled: true
@@ -374,37 +765,366 @@ Resources:
       {{with $v.container.port}}
       Port: {{.}}{{end}}
       VpcId: {{$.aws.vpc}}
+{{end}}{{end}}
+{{with $v.load_balancer}}
+  {{logicalid $k "ALB"}}:
+    Type: AWS::ElasticLoadBalancingV2::LoadBalancer
+    Properties:
+      LoadBalancerAttributes:
+        - Key: idle_timeout.timeout_seconds
+          Value: {{with .idle_timeout}}{{.}}{{else}}60{{end}}
+      {{with .access_logging}}{{if .bucket}}
+        - Key: access_logs.s3.enabled
+          Value: true
+        - Key: access_logs.s3.bucket
+          Value: {{.bucket}}
+        {{if .prefix}}
+        - Key: access_logs.s3.prefix
+          Value: {{.prefix}}
+        {{end}}
+      {{end}}{{end}}
+      Scheme: {{with .scheme}}{{.}}{{else}}internal{{end}}
+      SecurityGroups:
+      {{if .security_groups}}
+      {{range .security_groups}}
+        - '{{.}}'
+      {{end}}
+      {{else}}
+        - !GetAtt {{logicalid $k "ALBSG"}}.GroupId
+      {{end}}
+      Subnets:
+      {{range .subnets}}
+        - {{.}}
+      {{end}}
+      Type: application
+
+  {{if not .security_groups}}
+  {{logicalid $k "ALBSG"}}:
+    Type: AWS::EC2::SecurityGroup
+    Properties:
+      GroupDescription: ALB SG for {{$.name}} {{$k}}
+      VpcId: {{$.aws.vpc}}
+      SecurityGroupIngress:
+      {{$certs := .certificates}}{{$testport := .test_port}}
+      {{range $source := (or .allow_ingress_from (list "0.0.0.0/0"))}}
+        - CidrIp{{if contains $source ":"}}v6{{end}}: {{$source}}
+          Description: "HTTP"
+          FromPort: 80
+          ToPort: 80
+          IpProtocol: tcp
+        {{if $certs}}
+        - CidrIp{{if contains $source ":"}}v6{{end}}: {{$source}}
+          Description: "HTTPS"
+          FromPort: 443
+          ToPort: 443
+          IpProtocol: tcp
+        {{end}}
+        {{if $bluegreen}}{{with $testport}}
+        - CidrIp{{if contains $source ":"}}v6{{end}}: {{$source}}
+          Description: "Blue/green test traffic"
+          FromPort: {{.}}
+          ToPort: {{.}}
+          IpProtocol: tcp
+        {{end}}{{end}}
+      {{end}}
+
+  {{logicalid $k "ALBTaskIngress"}}:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      Description: "{{$k}}: requests from the ALB"
+      GroupId: !GetAtt TaskSG.GroupId
+      SourceSecurityGroupId: !GetAtt {{logicalid $k "ALBSG"}}.GroupId
+      FromPort: {{$v.container.port}}
+      ToPort: {{$v.container.port}}
+      IpProtocol: tcp
+  {{with $v.health_check.port}}{{if ne (print .) (print $v.container.port)}}
+  {{logicalid $k "ALBTaskHealthCheckIngress"}}:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      Description: "{{$k}}: health checks from the ALB"
+      GroupId: !GetAtt TaskSG.GroupId
+      SourceSecurityGroupId: !GetAtt {{logicalid $k "ALBSG"}}.GroupId
+      FromPort: {{.}}
+      ToPort: {{.}}
+      IpProtocol: tcp
+  {{end}}{{end}}
+  {{end}}
+
+  {{logicalid $k "Listener"}}:
+    Type: AWS::ElasticLoadBalancingV2::Listener
+    Properties:
+      DefaultActions:
+        - Type: forward
+          {{/* blue/green listeners stay on the target group CodeDeploy moved traffic to, see pinLiveTargetGroup */}}
+          TargetGroupArn: {{with $v.target_group}}{{.}}{{else}}{{if and $._live_target_group (eq $k $cdk)}}'{{$._live_target_group}}'{{else}}!Ref {{logicalid $k "TargetGroup"}}{{end}}{{end}}
+      LoadBalancerArn: !Ref {{logicalid $k "ALB"}}
+      {{if .certificates}}
+      Certificates:
+        - CertificateArn: '{{index .certificates 0}}'
+      Port: 443
+      Protocol: HTTPS
+      {{with .ssl_policy}}SslPolicy: {{.}}{{end}}
+      {{else}}
+      Port: 80
+      Protocol: HTTP
+      {{end}}
+
+  {{if $bluegreen}}{{with .test_port}}
+  {{logicalid $k "TestListener"}}:
+    Type: AWS::ElasticLoadBalancingV2::Listener
+    Properties:
+      DefaultActions:
+        - Type: forward
+          TargetGroupArn: {{if and $._live_target_group (eq $k $cdk)}}'{{$._live_target_group}}'{{else}}!Ref {{logicalid $k "TargetGroup"}}{{end}}
+      LoadBalancerArn: !Ref {{logicalid $k "ALB"}}
+      Port: {{.}}
+      {{if $v.load_balancer.certificates}}
+      Certificates:
+        - CertificateArn: '{{index $v.load_balancer.certificates 0}}'
+      Protocol: HTTPS
+      {{with $v.load_balancer.ssl_policy}}SslPolicy: {{.}}{{end}}
+      {{else}}
+      Protocol: HTTP
+      {{end}}
+  {{end}}{{end}}
+
+  {{if and .certificates (gt (len .certificates) 1)}}
+  {{logicalid $k "ListenerCertificates"}}:
+    Type: AWS::ElasticLoadBalancingV2::ListenerCertificate
+    Properties:
+      Certificates:
+      {{range $i, $c := .certificates}}{{if $i}}
+        - CertificateArn: '{{$c}}'
+      {{end}}{{end}}
+      ListenerArn: !Ref {{logicalid $k "Listener"}}
+  {{end}}
+
+  {{if and .certificates (ne (print .redirect_http) "false")}}
+  {{logicalid $k "HTTPListener"}}:
+    Type: AWS::ElasticLoadBalancingV2::Listener
+    Properties:
+      DefaultActions:
+        - Type: redirect
+          RedirectConfig:
+            Port: '443'
+            Protocol: HTTPS
+            StatusCode: HTTP_301
+      LoadBalancerArn: !Ref {{logicalid $k "ALB"}}
+      Port: 80
+      Protocol: HTTP
+  {{end}}
+
+  {{range $dk, $dv := .dns}}
+  {{logicalid $dk $k "DNS"}}:
+    Type: AWS::Route53::RecordSet
+    Properties:
+      AliasTarget:
+        DNSName: !GetAtt {{logicalid $k "ALB"}}.DNSName
+        HostedZoneId: !GetAtt {{logicalid $k "ALB"}}.CanonicalHostedZoneID
+      {{with $dv.zone}}HostedZoneName: {{.}}
+      {{else}}HostedZoneId: {{$dv.zone_id}}{{end}}
+      Name: {{$dk}}
+      SetIdentifier: "{{$.name}} {{$k}} {{$dk}}"
+      Type: A
+      Weight: {{with $dv.weight}}{{.}}{{else}}100{{end}}
+  {{end}}
 {{end}}
 {{range $lk, $lv := $v.listener_rules}}
   {{logicalid $k $lk "ListenerRule"}}:
     Type: AWS::ElasticLoadBalancingV2::ListenerRule
     Properties:
       Actions:
-        - TargetGroupArn: {{with $v.target_group}}{{.}}{{else}}!Ref {{logicalid $k "TargetGroup"}}{{end}}
+      {{with $lv.authenticate_oidc}}
+        - Type: authenticate-oidc
+          Order: 1
+          AuthenticateOidcConfig:
+            AuthorizationEndpoint: {{.authorization_endpoint}}
+            ClientId: {{.client_id}}
+            ClientSecret: {{quote .client_secret}}
+            Issuer: {{.issuer}}
+            TokenEndpoint: {{.token_endpoint}}
+            UserInfoEndpoint: {{.user_info_endpoint}}
+            {{with .scope}}Scope: {{.}}{{end}}
+            {{with .session_cookie_name}}SessionCookieName: {{.}}{{end}}
+            {{with .session_timeout}}SessionTimeout: {{.}}{{end}}
+            {{with .on_unauthenticated_request}}OnUnauthenticatedRequest: {{.}}{{end}}
+      {{end}}
+      {{with $lv.authenticate_cognito}}
+        - Type: authenticate-cognito
+          Order: 1
+          AuthenticateCognitoConfig:
+            UserPoolArn: {{.user_pool_arn}}
+            UserPoolClientId: {{.user_pool_client_id}}
+            UserPoolDomain: {{.user_pool_domain}}
+            {{with .scope}}Scope: {{.}}{{end}}
+            {{with .session_cookie_name}}SessionCookieName: {{.}}{{end}}
+            {{with .session_timeout}}SessionTimeout: {{.}}{{end}}
+            {{with .on_unauthenticated_request}}OnUnauthenticatedRequest: {{.}}{{end}}
+      {{end}}
+      {{if $lv.fixed_response}}
+        - Type: fixed-response
+          FixedResponseConfig:
+            StatusCode: '{{$lv.fixed_response.status_code}}'
+            {{with $lv.fixed_response.content_type}}ContentType: {{.}}{{end}}
+            {{with $lv.fixed_response.message_body}}MessageBody: {{quote .}}{{end}}
+      {{else if $lv.redirect}}
+        - Type: redirect
+          RedirectConfig:
+            StatusCode: HTTP_{{with $lv.redirect.status_code}}{{.}}{{else}}301{{end}}
+            {{with $lv.redirect.protocol}}Protocol: {{.}}{{end}}
+            {{with $lv.redirect.host}}Host: '{{.}}'{{end}}
+            {{with $lv.redirect.port}}Port: '{{.}}'{{end}}
+            {{with $lv.redirect.path}}Path: '{{.}}'{{end}}
+            {{with $lv.redirect.query}}Query: '{{.}}'{{end}}
+      {{else}}
+        - TargetGroupArn: {{with $v.target_group}}{{.}}{{else}}{{if and $._live_target_group (eq $k $cdk)}}'{{$._live_target_group}}'{{else}}!Ref {{logicalid $k "TargetGroup"}}{{end}}{{end}}
           Type: forward
+      {{end}}
+      {{if or $lv.authenticate_oidc $lv.authenticate_cognito}}
+          Order: 2
+      {{end}}
       Conditions:
       {{with $lv.path}}
         - Field: path-pattern
           PathPatternConfig:
             Values:
-              - '{{.}}'{{end}}
+            {{range aslist .}}
+              - '{{.}}'{{end}}{{end}}
       {{with $lv.hostname}}
         - Field: host-header
           HostHeaderConfig:
             Values:
-              - '{{.}}'{{end}}
-      ListenerArn: {{$lv.listener_arn}}
+            {{range aslist .}}
+              - '{{.}}'{{end}}{{end}}
+      {{range $hk, $hv := $lv.http_headers}}
+        - Field: http-header
+          HttpHeaderConfig:
+            HttpHeaderName: '{{$hk}}'
+            Values:
+            {{range aslist $hv}}
+              - '{{.}}'{{end}}{{end}}
+      {{with $lv.http_methods}}
+        - Field: http-request-method
+          HttpRequestMethodConfig:
+            Values:
+            {{range aslist .}}
+              - {{.}}{{end}}{{end}}
+      {{with $lv.query_strings}}
+        - Field: query-string
+          QueryStringConfig:
+            Values:
+            {{range $qk, $qv := .}}{{range aslist $qv}}
+              - Key: '{{$qk}}'
+                Value: '{{.}}'{{end}}{{end}}{{end}}
+      {{with $lv.source_ips}}
+        - Field: source-ip
+          SourceIpConfig:
+            Values:
+            {{range aslist .}}
+              - {{.}}{{end}}{{end}}
+      ListenerArn: {{with $lv.listener_arn}}{{.}}{{else}}!Ref {{logicalid $k "Listener"}}{{end}}
       Priority: {{with $lv.priority}}{{.}}{{else}}!GetAtt {{logicalid $k $lk "ListenerRulePriorityCalc"}}.priority
 
   {{logicalid $k $lk "ListenerRulePriorityCalc"}}:
     Type: Custom::ListenerRulePriorityCalc
     Properties:
       ServiceToken: {{$.yeet.priority_calculator_func_arn}}
-      ListenerArn: {{$lv.listener_arn}}
-      {{with $lv.path}}AlbPath: '{{.}}'{{end}}
-      {{with $lv.hostname}}AlbHostname: '{{.}}'{{end}}
+      ListenerArn: {{with $lv.listener_arn}}{{.}}{{else}}!Ref {{logicalid $k "Listener"}}{{end}}
+      {{with $lv.path}}AlbPath: {{rulevalues .}}{{end}}
+      {{with $lv.hostname}}AlbHostname: {{rulevalues .}}{{end}}
+      {{with $lv.http_headers}}AlbHttpHeaders:{{range $hk, $hv := .}}
+        '{{$hk}}': {{rulevalues $hv}}{{end}}{{end}}
+      {{with $lv.http_methods}}AlbHttpMethods: {{rulevalues .}}{{end}}
+      {{with $lv.query_strings}}AlbQueryStrings:{{range $qk, $qv := .}}
+        '{{$qk}}': {{rulevalues $qv}}{{end}}{{end}}
+      {{with $lv.source_ips}}AlbSourceIps: {{rulevalues .}}{{end}}
+{{end}}
 {{end}}
 {{end}}
+
+{{/* CodeDeploy moves traffic on a single ALB, which checkBlueGreen makes sure there is */}}
+{{if $cdk}}
+  CodeDeployRole:
+    Type: AWS::IAM::Role
+    Properties:
+      AssumeRolePolicyDocument:
+        Version: 2012-10-17
+        Statement:
+          - Effect: Allow
+            Principal:
+              Service:
+                - codedeploy.amazonaws.com
+            Action: sts:AssumeRole
+      Description: IAM role for CodeDeploy to deploy {{$.name}} blue/green
+      ManagedPolicyArns:
+        - !Sub 'arn:${AWS::Partition}:iam::aws:policy/AWSCodeDeployRoleForECS'
+
+  CodeDeployApplication:
+    Type: AWS::CodeDeploy::Application
+    Properties:
+      ComputePlatform: ECS
+
+  {{with $.aws.ecs.deployment.blue_green}}{{if ne (print .traffic_shifting) "all_at_once"}}
+  CodeDeployConfig:
+    Type: AWS::CodeDeploy::DeploymentConfig
+    Properties:
+      ComputePlatform: ECS
+      TrafficRoutingConfig:
+      {{if eq (print .traffic_shifting) "canary"}}
+        Type: TimeBasedCanary
+        TimeBasedCanary:
+          CanaryInterval: {{.interval}}
+          CanaryPercentage: {{.percentage}}
+      {{else}}
+        Type: TimeBasedLinear
+        TimeBasedLinear:
+          LinearInterval: {{.interval}}
+          LinearPercentage: {{.percentage}}
+      {{end}}
+  {{end}}{{end}}
+
+  CodeDeployDeploymentGroup:
+    Type: AWS::CodeDeploy::DeploymentGroup
+    Properties:
+      ApplicationName: !Ref CodeDeployApplication
+      AutoRollbackConfiguration:
+        Enabled: true
+        Events:
+          - DEPLOYMENT_FAILURE
+          - DEPLOYMENT_STOP_ON_REQUEST
+      BlueGreenDeploymentConfiguration:
+        DeploymentReadyOption:
+          ActionOnTimeout: CONTINUE_DEPLOYMENT
+        TerminateBlueInstancesOnDeploymentSuccess:
+          Action: TERMINATE
+          TerminationWaitTimeInMinutes: {{$.aws.ecs.deployment.blue_green.termination_wait}}
+      DeploymentConfigName: {{if eq (print $.aws.ecs.deployment.blue_green.traffic_shifting) "all_at_once"}}CodeDeployDefault.ECSAllAtOnce{{else}}!Ref CodeDeployConfig{{end}}
+      DeploymentStyle:
+        DeploymentOption: WITH_TRAFFIC_CONTROL
+        DeploymentType: BLUE_GREEN
+      ECSServices:
+        - ClusterName: {{suffix (print $.aws.ecs.cluster) "/"}}
+          ServiceName: !GetAtt Service.Name
+      LoadBalancerInfo:
+        TargetGroupPairInfoList:
+          - ProdTrafficRoute:
+              ListenerArns:
+              {{if $cdv.load_balancer}}
+                - !Ref {{logicalid $cdk "Listener"}}
+              {{else}}{{$prod := ""}}{{range $cdv.listener_rules}}{{if not $prod}}{{$prod = .listener_arn}}{{end}}{{end}}
+                - {{$prod}}
+              {{end}}
+            {{$testport := ""}}{{with $cdv.load_balancer}}{{$testport = .test_port}}{{end}}
+            {{if or $cdv.test_listener_arn $testport}}
+            TestTrafficRoute:
+              ListenerArns:
+                - {{with $cdv.test_listener_arn}}{{.}}{{else}}!Ref {{logicalid $cdk "TestListener"}}{{end}}
+            {{end}}
+            TargetGroups:
+            {{range $tg := $tgnames}}
+              - Name: !GetAtt {{logicalid $cdk $tg}}.TargetGroupName
+            {{end}}
+      ServiceRoleArn: !GetAtt CodeDeployRole.Arn
 {{end}}
 
 {{range $k, $v := $.aws.network_load_balancers}}
@@ -591,6 +1311,45 @@ Outputs:
     Description: ARN for the ECS Service
     Value: !Ref Service
 
+{{if $bluegreen}}
+  TaskDefinition:
+    Description: Task definition CodeDeploy deploys to the ECS Service
+    Value: {{with $.aws.ecs.task_definition}}'{{.}}'{{else}}!Ref TaskDefinition{{end}}
+
+  ServiceTaskDefinition:
+    Description: Task definition the ECS Service was created with, only CodeDeploy moves it on
+    Value: {{with $._service_task_definition}}'{{.}}'{{else}}{{with $.aws.ecs.task_definition}}'{{.}}'{{else}}!Ref TaskDefinition{{end}}{{end}}
+
+  CodeDeployApplication:
+    Description: CodeDeploy application for blue/green deployments
+    Value: !Ref CodeDeployApplication
+
+  CodeDeployDeploymentGroup:
+    Description: CodeDeploy deployment group for blue/green deployments
+    Value: !Ref CodeDeployDeploymentGroup
+{{end}}
+
+{{if $loggroupcreated}}
+  ServiceLogGroup:
+    Description: Log group for containers without their own logs.group
+    Value: !Ref ServiceLogGroup
+{{end}}
+{{if $tasksg}}
+  TaskSecurityGroup:
+    Description: Security group of the tasks{{if $.aws.ecs.task.export_security_group}}, other yeet stacks allow it with stack:{{$.name}}{{end}}
+    Value: !GetAtt TaskSG.GroupId
+    {{if $.aws.ecs.task.export_security_group}}
+    Export:
+      Name: !Sub '${AWS::StackName}-TaskSecurityGroup'
+    {{end}}
+{{end}}
+
+{{range $k, $v := $.aws.application_load_balancers}}{{if $v.load_balancer}}
+  {{logicalid $k "ALBDNSName"}}:
+    Description: DNS name of the {{$k}} ALB
+    Value: !GetAtt {{logicalid $k "ALB"}}.DNSName
+{{end}}{{end}}
+
   RandomValue:
     Description: Random value used by Yeet
     Value: {{$r}}